@@ -2,27 +2,42 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"github.com/Snyssfx/container_scheduler/internal/api"
+	"github.com/Snyssfx/container_scheduler/internal/cache"
+	"github.com/Snyssfx/container_scheduler/internal/config"
+	"github.com/Snyssfx/container_scheduler/internal/containers"
 	"github.com/Snyssfx/container_scheduler/internal/containersmap"
 	"github.com/Snyssfx/container_scheduler/internal/deduplicator"
+	"github.com/Snyssfx/container_scheduler/internal/forecast"
+	"github.com/Snyssfx/container_scheduler/internal/jobs"
+	"github.com/Snyssfx/container_scheduler/internal/reconciler"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
-var serverPort = flag.Int("port", 9002, "a port that a server should listen for user requests")
-
 func main() {
+	cfg, err := config.Load(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot load config: %s\n", err.Error())
+		os.Exit(2)
+	}
+	startLevel, _ := cfg.Level()
+	level := zap.NewAtomicLevelAt(startLevel)
+
 	// context with graceful shutdown
 	ctx, stopFn := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL)
 	defer stopFn()
 
-	flag.Parse()
-
 	log := zap.New(
 		zapcore.NewCore(
 			zapcore.NewConsoleEncoder(zapcore.EncoderConfig{
@@ -38,32 +53,163 @@ func main() {
 				EncodeCaller:   zapcore.ShortCallerEncoder,
 			}),
 			zapcore.AddSync(os.Stdout),
-			zap.NewAtomicLevelAt(zap.DebugLevel),
+			level,
 		),
 	).Sugar()
+	log.Debugf("config:\n%s", cfg)
 
-	deduplicatorFabricFn := func(l *zap.SugaredLogger, seed int) (containersmap.RequestDeduplicator, error) {
-		return deduplicator.NewCachedDeduplicator(l.Named("cached"), seed)
+	memCache, err := cache.New(log.Named("cache"), cfg.CacheConfig())
+	if err != nil {
+		log.Fatalf("cannot create cache: %s", err.Error())
 	}
 
-	cm := containersmap.New(log.Named("cm"), deduplicatorFabricFn)
-	defer func() {
-		errClose := cm.Close()
-		if errClose != nil {
-			log.Errorf("cannot close containers map: %s", errClose.Error())
+	var resultCache deduplicator.ResultCache = memCache
+	if cfg.Cache.File != "" {
+		persistent, err := cache.OpenPersistent(log.Named("persistent"), cfg.Cache.File, memCache)
+		if err != nil {
+			log.Fatalf("cannot open persistent cache: %s", err.Error())
 		}
-	}()
+		defer func() {
+			errClose := persistent.Close()
+			if errClose != nil {
+				log.Errorf("cannot close persistent cache: %s", errClose.Error())
+			}
+		}()
 
-	s := api.NewServer(log.Named("main_server"), cm, *serverPort)
-	go s.Serve()
-	defer func() {
-		errClose := s.Close()
-		if errClose != nil {
-			log.Errorf("cannot close main server: %s", errClose.Error())
+		resultCache = persistent
+	}
+
+	schedulingPolicy, err := cfg.SchedulingPolicy()
+	if err != nil {
+		log.Fatalf("cannot create scheduling policy: %s", err.Error())
+	}
+
+	images := containers.DefaultImages()
+	if cfg.Images != "" {
+		images, err = containers.LoadImages(cfg.Images)
+		if err != nil {
+			log.Fatalf("cannot load images: %s", err.Error())
+		}
+	}
+	if _, err = images.Get(cfg.Server.DefaultImage); err != nil {
+		log.Fatalf("invalid default image: %s", err.Error())
+	}
+
+	// cm, rl and fc are created after the fabric, it is not called before requests
+	var (
+		cm *containersmap.ContainersMap
+		rl *reloader
+		fc *forecast.Forecaster
+	)
+	deduplicatorConfig := func(key containersmap.Key) deduplicator.Config {
+		dCfg := rl.deduplicatorConfig()
+		dCfg.Breaker = cm.Breaker(key)
+		if fc != nil {
+			dCfg.OnMiss = func() { fc.Observe(key) }
+		}
+		return dCfg
+	}
+	deduplicatorFabricFn := func(l *zap.SugaredLogger, key containersmap.Key) (containersmap.RequestDeduplicator, error) {
+		spec, err := images.Get(key.Image)
+		if err != nil {
+			return nil, err
+		}
+
+		d, err := deduplicator.NewCachedDeduplicator(l.Named("cached"), spec, key.Seed, resultCache, deduplicatorConfig(key))
+		if err != nil {
+			return nil, err
+		}
+
+		return d, nil
+	}
+
+	cm = containersmap.New(log.Named("cm"), deduplicatorFabricFn, cfg.ContainersMapConfig())
+	go cm.Run(ctx)
+
+	rl = newReloader(log.Named("reload"), level, cfg, cfg.DeduplicatorConfig(schedulingPolicy), memCache, cm)
+	go rl.run(ctx)
+
+	if cfg.Forecast.Enabled {
+		fc, err = forecast.New(log.Named("forecast"), cm, cfg.ForecastConfig())
+		if err != nil {
+			log.Fatalf("cannot create forecaster: %s", err.Error())
+		}
+		go fc.Run(ctx)
+	}
+
+	idleTimeouts, _ := cfg.SeedIdleTimeouts()
+	for key, idle := range idleTimeouts {
+		cm.SetIdleTimeout(key.Image, key.Seed, idle)
+	}
+
+	// containers of a previous run that crashed are adopted before the first request
+	inventory, err := containers.NewInventory(log.Named("inventory"), rl.deduplicatorConfig().Containers)
+	if err != nil {
+		log.Fatalf("cannot create containers inventory: %s", err.Error())
+	}
+	adoptFn := func(image string, seed int, quals []*containers.Qual) error {
+		spec, _ := images.Get(image) // the reconciler adopts containers of known images only
+		key := containersmap.Key{Image: image, Seed: seed}
+		d, err := deduplicator.NewCachedDeduplicator(log.Named("cm").Named("cached"), spec, seed, resultCache, deduplicatorConfig(key), quals...)
+		if err != nil {
+			for _, q := range quals {
+				_ = q.Close()
+			}
+			return err
+		}
+
+		err = cm.Adopt(key, d)
+		if err != nil {
+			_ = d.Close()
+			return err
 		}
-	}()
+
+		return nil
+	}
+	err = reconciler.New(log.Named("reconciler"), cfg.OrphansPolicy(), inventory, images, adoptFn).Run()
+	if err != nil {
+		log.Errorf("cannot reconcile containers of a previous run: %s", err.Error())
+	}
+
+	pinned, _ := cfg.PinnedSeeds()
+	for _, key := range pinned {
+		err = cm.Pin(ctx, key.Image, key.Seed)
+		if err != nil {
+			log.Errorf("cannot pin seed %d of %s: %s", key.Seed, key.Image, err.Error())
+		}
+	}
+
+	jobManager, err := jobs.New(log.Named("jobs"), cm, cfg.JobsConfig())
+	if err != nil {
+		log.Fatalf("cannot create jobs: %s", err.Error())
+	}
+
+	s := api.NewServer(log.Named("main_server"), cm, jobManager, cfg.APIConfig())
+	go s.Serve()
 
 	log.Info("Server has been started.")
 	<-ctx.Done()
+	log.Info("Shutting down.")
+
+	// requests in flight still need containers, so the server is drained first
+	err = s.Shutdown()
+	if err != nil {
+		log.Errorf("cannot shutdown main server: %s", err.Error())
+	}
+
+	// jobs are canceled as they have no clients to wait for
+	jobManager.Close()
+
+	err = cm.Close()
+	if err != nil {
+		log.Errorf("cannot close containers map: %s", err.Error())
+	}
+
+	// containers that failed to stop or are being evicted are removed by force
+	err = containers.RemoveAll(log.Named("cleanup"), rl.deduplicatorConfig().Containers)
+	if err != nil {
+		log.Errorf("cannot remove leftover containers: %s", err.Error())
+	}
+
 	log.Info("See you soon.")
 }
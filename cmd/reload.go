@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/Snyssfx/container_scheduler/internal/cache"
+	"github.com/Snyssfx/container_scheduler/internal/config"
+	"github.com/Snyssfx/container_scheduler/internal/containersmap"
+	"github.com/Snyssfx/container_scheduler/internal/deduplicator"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+)
+
+// reloader applies a new config to running components on SIGHUP.
+// Calculations in flight are not interrupted.
+type reloader struct {
+	l     *zap.SugaredLogger
+	level zap.AtomicLevel
+	cache *cache.Cache
+	cm    *containersmap.ContainersMap
+	// cfg is used by the reloading goroutine only.
+	cfg config.Config
+
+	mu              sync.Mutex
+	deduplicatorCfg deduplicator.Config
+}
+
+func newReloader(
+	l *zap.SugaredLogger, level zap.AtomicLevel, cfg config.Config, deduplicatorCfg deduplicator.Config,
+	c *cache.Cache, cm *containersmap.ContainersMap,
+) *reloader {
+	return &reloader{
+		l:     l,
+		level: level,
+		cache: c,
+		cm:    cm,
+		cfg:   cfg,
+
+		mu:              sync.Mutex{},
+		deduplicatorCfg: deduplicatorCfg,
+	}
+}
+
+// deduplicatorConfig returns the current config for new deduplicators.
+func (r *reloader) deduplicatorConfig() deduplicator.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.deduplicatorCfg
+}
+
+// run reloads the config on every SIGHUP until the context is done.
+func (r *reloader) run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-hup:
+			r.reload()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reload reads the config from the same flags, environment and file as at startup.
+// An invalid config is logged and the current one stays in effect.
+func (r *reloader) reload() {
+	next, err := config.Load(os.Args[1:], os.LookupEnv)
+	if err != nil {
+		r.l.Errorf("cannot reload config, keep the current one: %s", err.Error())
+		return
+	}
+
+	next, ignored := r.cfg.Reloadable(next)
+	if len(ignored) > 0 {
+		r.l.Warnf("changes of %s need a restart and are ignored", strings.Join(ignored, ", "))
+	}
+
+	level, _ := next.Level()
+	schedulingPolicy, _ := next.SchedulingPolicy()
+	deduplicatorCfg := next.DeduplicatorConfig(schedulingPolicy)
+
+	// everything is checked before the first change, so a failed reload changes nothing
+	err = multierr.Combine(
+		next.CacheConfig().Validate(),
+		next.ContainersMapConfig().Validate(),
+		deduplicatorCfg.Containers.Validate(),
+	)
+	if err != nil {
+		r.l.Errorf("cannot reload config, keep the current one: %s", err.Error())
+		return
+	}
+
+	err = r.cache.SetConfig(next.CacheConfig())
+	if err != nil {
+		r.l.Errorf("cannot reload cache config: %s", err.Error())
+		return
+	}
+
+	err = r.cm.SetConfig(next.ContainersMapConfig())
+	if err != nil {
+		r.l.Errorf("cannot reload containers map config: %s", err.Error())
+		return
+	}
+
+	// new deduplicators get the new config, existing ones are changed after that
+	r.mu.Lock()
+	r.deduplicatorCfg = deduplicatorCfg
+	r.mu.Unlock()
+
+	err = r.cm.SetContainersConfig(deduplicatorCfg.Containers)
+	if err != nil {
+		r.l.Errorf("cannot reload containers config: %s", err.Error())
+		return
+	}
+
+	r.level.SetLevel(level)
+	if changed := r.cfg.NewSeedsOnly(next); len(changed) > 0 {
+		r.l.Warnf(
+			"changes of %s apply only to new seeds, running seeds keep theirs until they are evicted or collected",
+			strings.Join(changed, ", "),
+		)
+	}
+	r.cfg = next
+
+	r.l.Infof("config reloaded")
+	r.l.Debugf("config:\n%s", next)
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// maxDurationSize is a maximum size of a duration in a request body.
+const maxDurationSize = 64
+
+// warmHandler starts containers of the seed in background.
+func (s *Server) warmHandler(w http.ResponseWriter, r *http.Request) {
+	s.handleSeed(w, r, http.StatusAccepted, func(ctx context.Context, image string, seed int) error {
+		return s.containersMap.Warm(ctx, image, seed)
+	})
+}
+
+// pinHandler warms the seed and keeps its containers running.
+func (s *Server) pinHandler(w http.ResponseWriter, r *http.Request) {
+	s.handleSeed(w, r, http.StatusAccepted, func(ctx context.Context, image string, seed int) error {
+		return s.containersMap.Pin(ctx, image, seed)
+	})
+}
+
+// unpinHandler returns the seed to the usual idle timeout.
+func (s *Server) unpinHandler(w http.ResponseWriter, r *http.Request) {
+	s.handleSeed(w, r, http.StatusNoContent, func(_ context.Context, image string, seed int) error {
+		return s.containersMap.Unpin(image, seed)
+	})
+}
+
+// seedsHandler lists states of all seeds with their containers and queues.
+func (s *Server) seedsHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(s.containersMap.Status())
+	if err != nil {
+		s.l.Errorf("cannot write seeds: %s", err.Error())
+	}
+}
+
+// evictHandler stops containers of the seed and removes it, requests in flight fail.
+func (s *Server) evictHandler(w http.ResponseWriter, r *http.Request) {
+	s.handleSeed(w, r, http.StatusNoContent, func(_ context.Context, image string, seed int) error {
+		return s.containersMap.Evict(image, seed)
+	})
+}
+
+// setIdleTimeoutHandler overrides the idle timeout of the seed by a duration in the body, e.g. 10m.
+func (s *Server) setIdleTimeoutHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxDurationSize))
+	if err != nil {
+		s.l.Errorf("cannot read idle timeout: %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	idle, err := time.ParseDuration(strings.TrimSpace(string(body)))
+	if err != nil || idle <= 0 {
+		s.l.Errorf("invalid idle timeout %q", body)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.handleSeed(w, r, http.StatusNoContent, func(_ context.Context, image string, seed int) error {
+		s.containersMap.SetIdleTimeout(image, seed, idle)
+		return nil
+	})
+}
+
+// resetIdleTimeoutHandler returns the seed to the adaptive idle timeout or the one of the config.
+func (s *Server) resetIdleTimeoutHandler(w http.ResponseWriter, r *http.Request) {
+	s.handleSeed(w, r, http.StatusNoContent, func(_ context.Context, image string, seed int) error {
+		s.containersMap.SetIdleTimeout(image, seed, 0)
+		return nil
+	})
+}
+
+// handleSeed parses the image and the seed of the route and calls fn with them.
+func (s *Server) handleSeed(
+	w http.ResponseWriter, r *http.Request, okStatus int, fn func(ctx context.Context, image string, seed int) error,
+) {
+	vars := mux.Vars(r)
+	seed, err := strconv.Atoi(vars["seed"])
+	if err != nil {
+		s.l.Errorf("cannot parse seed: %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = fn(r.Context(), s.image(vars), seed)
+	if err != nil {
+		s.l.Errorf("cannot handle seed %d: %s", seed, err.Error())
+		s.writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(okStatus)
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Snyssfx/container_scheduler/internal/api/mock"
+	"github.com/Snyssfx/container_scheduler/internal/containers"
+	"github.com/Snyssfx/container_scheduler/internal/containersmap"
+	"github.com/Snyssfx/container_scheduler/internal/snapshot"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestServer_adminSeedHandlers(t *testing.T) {
+	cm := mock.NewContainersMapMock(t)
+	cm.WarmMock.Set(func(ctx context.Context, image string, seed int) (err error) {
+		if image != "qual-2021" {
+			return fmt.Errorf("cannot create deduplicator: %w", containers.ErrUnknownImage)
+		}
+		return nil
+	})
+	cm.PinMock.Set(func(ctx context.Context, image string, seed int) (err error) {
+		if seed == 2 {
+			return fmt.Errorf("%w for seed 2", containersmap.ErrCapacity)
+		}
+		return nil
+	})
+	cm.UnpinMock.Set(func(image string, seed int) (err error) {
+		if seed == 2 {
+			return containersmap.ErrNotPinned
+		}
+		return nil
+	})
+	s := NewServer(zap.NewNop().Sugar(), cm, mock.NewJobManagerMock(t), Config{DefaultImage: "qual-2021"})
+
+	for _, tc := range []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodPost, "/admin/seeds/1/warm", http.StatusAccepted},
+		{http.MethodPost, "/admin/seeds/qual-2021/1/warm", http.StatusAccepted},
+		{http.MethodPost, "/admin/seeds/other/1/warm", http.StatusNotFound},
+		{http.MethodPut, "/admin/seeds/1/pin", http.StatusAccepted},
+		{http.MethodPut, "/admin/seeds/2/pin", http.StatusServiceUnavailable},
+		{http.MethodDelete, "/admin/seeds/1/pin", http.StatusNoContent},
+		{http.MethodDelete, "/admin/seeds/2/pin", http.StatusNotFound},
+		{http.MethodGet, "/admin/seeds/1/warm", http.StatusMethodNotAllowed},
+	} {
+		w := httptest.NewRecorder()
+		s.admin.Handler.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		assert.Equal(t, tc.want, w.Code, "%s %s", tc.method, tc.path)
+	}
+}
+
+func TestServer_idleTimeoutHandlers(t *testing.T) {
+	cm := mock.NewContainersMapMock(t)
+	var got []time.Duration
+	cm.SetIdleTimeoutMock.Set(func(image string, seed int, idle time.Duration) {
+		assert.Equal(t, "qual-2021", image)
+		assert.Equal(t, 1, seed)
+		got = append(got, idle)
+	})
+	s := NewServer(zap.NewNop().Sugar(), cm, mock.NewJobManagerMock(t), Config{DefaultImage: "qual-2021"})
+
+	for _, tc := range []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodPut, "/admin/seeds/1/idle-timeout", "10m\n", http.StatusNoContent},
+		{http.MethodPut, "/admin/seeds/qual-2021/1/idle-timeout", "1h", http.StatusNoContent},
+		{http.MethodPut, "/admin/seeds/1/idle-timeout", "soon", http.StatusBadRequest},
+		{http.MethodPut, "/admin/seeds/1/idle-timeout", "-1m", http.StatusBadRequest},
+		{http.MethodDelete, "/admin/seeds/1/idle-timeout", "", http.StatusNoContent},
+	} {
+		w := httptest.NewRecorder()
+		s.admin.Handler.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)))
+		assert.Equal(t, tc.want, w.Code, "%s %s %q", tc.method, tc.path, tc.body)
+	}
+
+	assert.Equal(t, []time.Duration{10 * time.Minute, time.Hour, 0}, got)
+}
+
+func TestServer_seedsHandler(t *testing.T) {
+	cm := mock.NewContainersMapMock(t)
+	cm.StatusMock.Return([]snapshot.Seed{{
+		Image:   "qual-2021",
+		Seed:    1,
+		Circuit: snapshot.CircuitClosed,
+		Deduplicator: snapshot.Deduplicator{
+			Containers:  []snapshot.Container{{Name: "qual_9090_seed_1", Port: 9090, State: "ready", IdleTimeout: "2m0s"}},
+			Calculating: []snapshot.Input{{Input: 3, Subscribers: 2}},
+			Queued:      []snapshot.Input{},
+		},
+	}})
+	s := NewServer(zap.NewNop().Sugar(), cm, mock.NewJobManagerMock(t), Config{DefaultImage: "qual-2021"})
+
+	w := httptest.NewRecorder()
+	s.admin.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/seeds", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `[{
+		"image": "qual-2021", "seed": 1, "pinned": false, "requests": 0, "last_used": "0001-01-01T00:00:00Z", "circuit": "closed",
+		"containers": [{
+			"name": "qual_9090_seed_1", "port": 9090, "state": "ready", "pinned": false, "in_flight": 0,
+			"last_calculation": "0001-01-01T00:00:00Z", "idle_timeout": "2m0s"
+		}],
+		"calculating": [{"input": 3, "subscribers": 2}],
+		"queued": [],
+		"cached_results": 0
+	}]`, w.Body.String())
+}
+
+func TestServer_evictHandler(t *testing.T) {
+	cm := mock.NewContainersMapMock(t)
+	cm.EvictMock.Set(func(image string, seed int) (err error) {
+		if seed == 2 {
+			return containersmap.ErrNotFound
+		}
+		return nil
+	})
+	s := NewServer(zap.NewNop().Sugar(), cm, mock.NewJobManagerMock(t), Config{DefaultImage: "qual-2021"})
+
+	for path, want := range map[string]int{
+		"/admin/seeds/1":           http.StatusNoContent,
+		"/admin/seeds/qual-2021/1": http.StatusNoContent,
+		"/admin/seeds/2":           http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		s.admin.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, path, nil))
+		assert.Equal(t, want, w.Code, path)
+	}
+}
+
+func TestServer_adminRoutesNotPublic(t *testing.T) {
+	s := NewServer(zap.NewNop().Sugar(), mock.NewContainersMapMock(t), mock.NewJobManagerMock(t), Config{DefaultImage: "qual-2021"})
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/admin/seeds", nil),
+		httptest.NewRequest(http.MethodDelete, "/admin/seeds/1", nil),
+		httptest.NewRequest(http.MethodPut, "/admin/seeds/1/pin", nil),
+		httptest.NewRequest(http.MethodPost, "/admin/seeds/1/warm", nil),
+	} {
+		w := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code, req.URL.Path)
+	}
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// DefaultMaxBatchSize is a default maximum count of inputs in one batch request.
+	DefaultMaxBatchSize = 1000
+	// DefaultBatchConcurrency is a default maximum count of simultaneous calculations of one batch.
+	DefaultBatchConcurrency = 64
+)
+
+// batchResult is one line of a batch response.
+type batchResult struct {
+	Input  int    `json:"input"`
+	Result *int   `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Status is an HTTP status the input would get from the calculate route, e.g. 504.
+	Status int `json:"status,omitempty"`
+}
+
+// batchHandler calculates a JSON array of inputs for one seed concurrently and
+// streams results as newline-delimited JSON in the order they finish.
+func (s *Server) batchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	seed, err := strconv.Atoi(vars["seed"])
+	if err != nil {
+		s.l.Errorf("cannot parse seed: %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var inputs []int
+	err = json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&inputs)
+	if err != nil {
+		s.l.Errorf("cannot parse inputs: %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if len(inputs) == 0 || len(inputs) > s.maxBatchSize {
+		s.l.Errorf("batch size %d is out of [1, %d]", len(inputs), s.maxBatchSize)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	enc := json.NewEncoder(w)
+	for res := range s.calculateBatch(r, s.image(vars), seed, inputs) {
+		err = enc.Encode(res)
+		if err != nil {
+			s.l.Errorf("cannot write batch result: %s", err.Error())
+			continue
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// calculateBatch sends results to the returned channel and closes it when all inputs are done.
+func (s *Server) calculateBatch(r *http.Request, image string, seed int, inputs []int) <-chan batchResult {
+	results := make(chan batchResult, len(inputs))
+	sem := make(chan struct{}, s.batchConcurrency)
+	wg := sync.WaitGroup{}
+
+	for _, input := range inputs {
+		wg.Add(1)
+		input := input
+		go func() {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			result, err := s.containersMap.Calculate(r.Context(), image, seed, input)
+			if err != nil {
+				s.l.Errorf("cannot calculate result for input %d: %s", input, err.Error())
+				results <- batchResult{Input: input, Error: err.Error(), Status: errorStatus(err)}
+				return
+			}
+
+			results <- batchResult{Input: input, Result: &result}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
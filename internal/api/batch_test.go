@@ -0,0 +1,67 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Snyssfx/container_scheduler/internal/api/mock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestServer_batchHandler(t *testing.T) {
+	cm := mock.NewContainersMapMock(t)
+	cm.CalculateMock.Set(func(ctx context.Context, image string, seed int, input int) (i1 int, err error) {
+		assert.Equal(t, 1234, seed)
+		if input < 0 {
+			return 0, errors.New("negative input")
+		}
+		return input * 2, nil
+	})
+
+	s := &Server{l: zap.NewNop().Sugar(), containersMap: cm, maxBatchSize: 3, batchConcurrency: 2}
+	req := httptest.NewRequest(http.MethodPost, "/calculate/1234", strings.NewReader(`[1, 2, -3]`))
+	w := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/calculate/{seed}", s.batchHandler)
+
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+
+	got := map[int]batchResult{}
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var res batchResult
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &res))
+		got[res.Input] = res
+	}
+
+	require.Len(t, got, 3)
+	assert.Equal(t, 2, *got[1].Result)
+	assert.Equal(t, 4, *got[2].Result)
+	assert.Nil(t, got[-3].Result)
+	assert.Equal(t, "negative input", got[-3].Error)
+	assert.Equal(t, http.StatusInternalServerError, got[-3].Status)
+}
+
+func TestServer_batchHandler_BadRequest(t *testing.T) {
+	s := &Server{l: zap.NewNop().Sugar(), containersMap: mock.NewContainersMapMock(t), maxBatchSize: 3}
+	router := mux.NewRouter()
+	router.HandleFunc("/calculate/{seed}", s.batchHandler)
+
+	for _, body := range []string{`[]`, `{"a": 1}`, `[1, "2"]`, `[1, 2, 3, 4]`} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/calculate/1", strings.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+}
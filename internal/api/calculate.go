@@ -9,8 +9,6 @@ import (
 
 // calculateHandler parses user input and gets a result from containersMap.
 func (s *Server) calculateHandler(w http.ResponseWriter, r *http.Request) {
-	// TODO: if we need metrics, we can add Requests, Errors, Durations here.
-
 	if r.Method != http.MethodGet {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
@@ -33,10 +31,10 @@ func (s *Server) calculateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := s.containersMap.Calculate(r.Context(), seed, input)
+	result, err := s.containersMap.Calculate(r.Context(), s.image(vars), seed, input)
 	if err != nil {
 		s.l.Errorf("cannot calculate result: %s", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		s.writeError(w, err)
 		return
 	}
 
@@ -3,17 +3,20 @@ package api
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/Snyssfx/container_scheduler/internal/api/mock"
+	"github.com/Snyssfx/container_scheduler/internal/containers"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
 )
 
 func TestServer_calculateHandler(t *testing.T) {
 	cm := mock.NewContainersMapMock(t)
-	cm.CalculateMock.Set(func(ctx context.Context, seed int, input int) (i1 int, err error) {
+	cm.CalculateMock.Set(func(ctx context.Context, image string, seed int, input int) (i1 int, err error) {
 		assert.Equal(t, 1234, seed)
 		assert.Equal(t, 4321, input)
 		return 3412, nil
@@ -30,3 +33,28 @@ func TestServer_calculateHandler(t *testing.T) {
 	assert.Equal(t, 200, w.Code)
 	assert.Equal(t, []byte(`3412`), w.Body.Bytes())
 }
+
+func TestServer_calculateHandler_Image(t *testing.T) {
+	cm := mock.NewContainersMapMock(t)
+	cm.CalculateMock.Set(func(ctx context.Context, image string, seed int, input int) (i1 int, err error) {
+		if image != "other" {
+			return 0, fmt.Errorf("%w: %q", containers.ErrUnknownImage, image)
+		}
+		return 3412, nil
+	})
+
+	s := &Server{containersMap: cm, defaultImage: "qual-2021"}
+	router := mux.NewRouter()
+	router.HandleFunc("/calculate/{seed}/{user_input}", s.calculateHandler)
+	router.HandleFunc("/calculate/"+imagePattern+"/{seed}/{user_input}", s.calculateHandler)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/calculate/other/1234/4321", bytes.NewReader(nil)))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, []byte(`3412`), w.Body.Bytes())
+
+	s.l = zap.NewNop().Sugar()
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/calculate/1234/4321", bytes.NewReader(nil)))
+	assert.Equal(t, 404, w.Code)
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Snyssfx/container_scheduler/internal/containers"
+	"github.com/Snyssfx/container_scheduler/internal/containersmap"
+	"github.com/Snyssfx/container_scheduler/internal/deduplicator"
+	"github.com/Snyssfx/container_scheduler/internal/jobs"
+)
+
+const (
+	// statusClientClosedRequest is returned for requests canceled by the client, as in nginx.
+	statusClientClosedRequest = 499
+	// retryAfter is a number of seconds clients wait before retrying an unavailable request.
+	retryAfter = 1
+)
+
+// errorResponse is a body of a failed request.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// errorStatus maps an error of the containers map or the job manager to an HTTP status.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, containers.ErrUnknownImage), errors.Is(err, containersmap.ErrNotFound),
+		errors.Is(err, containersmap.ErrNotPinned), errors.Is(err, jobs.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, containers.ErrBadInput):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, deduplicator.ErrCanceled), errors.Is(err, context.Canceled):
+		return statusClientClosedRequest
+	case errors.Is(err, containersmap.ErrCapacity), errors.Is(err, deduplicator.ErrClosed),
+		errors.Is(err, containers.ErrClosed), errors.Is(err, jobs.ErrTooManyJobs), errors.Is(err, jobs.ErrClosed),
+		errors.Is(err, containersmap.ErrCircuitOpen):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, containers.ErrInitTimeout), errors.Is(err, containers.ErrCalculationTimeout),
+		errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, containers.ErrStart), errors.Is(err, containers.ErrUnreachable),
+		errors.Is(err, containers.ErrBadUpstreamResponse), errors.Is(err, containers.ErrUpstreamFailure):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// retryAfterSeconds returns the time until a seed with an open breaker accepts requests
+// or the default one.
+func retryAfterSeconds(err error) int {
+	var errOpen *containersmap.CircuitOpenError
+	if errors.As(err, &errOpen) && errOpen.RetryAfter > time.Duration(retryAfter)*time.Second {
+		return int(math.Ceil(errOpen.RetryAfter.Seconds()))
+	}
+
+	return retryAfter
+}
+
+// writeError writes the status of the error with a JSON body, unavailable requests get Retry-After.
+func (s *Server) writeError(w http.ResponseWriter, err error) {
+	status := errorStatus(err)
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(err)))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err = json.NewEncoder(w).Encode(errorResponse{Error: err.Error()})
+	if err != nil {
+		s.l.Debugf("cannot write error: %s", err.Error())
+	}
+}
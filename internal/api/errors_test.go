@@ -0,0 +1,55 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Snyssfx/container_scheduler/internal/api/mock"
+	"github.com/Snyssfx/container_scheduler/internal/containers"
+	"github.com/Snyssfx/container_scheduler/internal/containersmap"
+	"github.com/Snyssfx/container_scheduler/internal/deduplicator"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestServer_calculateHandler_Errors(t *testing.T) {
+	for _, tc := range []struct {
+		err        error
+		want       int
+		retryAfter string
+	}{
+		{fmt.Errorf("%w: 1, 2: %s", deduplicator.ErrCanceled, context.Canceled), statusClientClosedRequest, ""},
+		{fmt.Errorf("%w for seed 1 of qual-2021 in 1s", containersmap.ErrCapacity), http.StatusServiceUnavailable, "1"},
+		{fmt.Errorf("%w: 1, 2", deduplicator.ErrClosed), http.StatusServiceUnavailable, "1"},
+		{&containersmap.CircuitOpenError{RetryAfter: 1500 * time.Millisecond}, http.StatusServiceUnavailable, "2"},
+		{&containersmap.CircuitOpenError{}, http.StatusServiceUnavailable, "1"},
+		{fmt.Errorf("cannot start a container: %w", containers.ErrInitTimeout), http.StatusGatewayTimeout, ""},
+		{fmt.Errorf("cannot calculate input 1: %w", containers.ErrCalculationTimeout), http.StatusGatewayTimeout, ""},
+		{fmt.Errorf("cannot calculate input 1: %w", containers.ErrBadUpstreamResponse), http.StatusBadGateway, ""},
+		{fmt.Errorf("cannot start a container: %w", containers.ErrStart), http.StatusBadGateway, ""},
+		{fmt.Errorf("cannot calculate input 1: %w: status 500", containers.ErrUpstreamFailure), http.StatusBadGateway, ""},
+		{fmt.Errorf("cannot calculate input 1: %w: status 400", containers.ErrBadInput), http.StatusUnprocessableEntity, ""},
+		{errors.New("something else"), http.StatusInternalServerError, ""},
+	} {
+		cm := mock.NewContainersMapMock(t)
+		cm.CalculateMock.Return(0, tc.err)
+		s := &Server{l: zap.NewNop().Sugar(), containersMap: cm}
+		router := mux.NewRouter()
+		router.HandleFunc("/calculate/{seed}/{user_input}", s.calculateHandler)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/calculate/1/2", bytes.NewReader(nil)))
+
+		assert.Equal(t, tc.want, w.Code, tc.err.Error())
+		assert.Equal(t, tc.retryAfter, w.Header().Get("Retry-After"), tc.err.Error())
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.JSONEq(t, fmt.Sprintf(`{"error": %q}`, tc.err.Error()), w.Body.String())
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+
+	"github.com/Snyssfx/container_scheduler/internal/containers"
+	"github.com/Snyssfx/container_scheduler/internal/jobs"
+	"github.com/gorilla/mux"
+)
+
+var imageRe = regexp.MustCompile("^" + containers.NamePattern + "$")
+
+// jobRequest is a body of a job submission, an empty image means the default one.
+type jobRequest struct {
+	Image string `json:"image"`
+	Seed  *int   `json:"seed"`
+	Input *int   `json:"input"`
+}
+
+// submitJobHandler starts a calculation in background and responds with its job.
+func (s *Server) submitJobHandler(w http.ResponseWriter, r *http.Request) {
+	var req jobRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&req)
+	if err != nil {
+		s.l.Errorf("cannot parse job: %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if req.Image == "" {
+		req.Image = s.defaultImage
+	}
+	if req.Seed == nil || req.Input == nil || *req.Seed < 0 || *req.Input < 0 || !imageRe.MatchString(req.Image) {
+		s.l.Errorf("invalid job: image %q, seed and input should be non-negative", req.Image)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	j, err := s.jobs.Submit(req.Image, *req.Seed, *req.Input)
+	if err != nil {
+		s.writeJobError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/jobs/"+j.ID)
+	s.writeJob(w, http.StatusAccepted, j)
+}
+
+// getJobHandler responds with the state of the job.
+func (s *Server) getJobHandler(w http.ResponseWriter, r *http.Request) {
+	j, err := s.jobs.Get(mux.Vars(r)["id"])
+	if err != nil {
+		s.writeJobError(w, err)
+		return
+	}
+
+	s.writeJob(w, http.StatusOK, j)
+}
+
+// cancelJobHandler cancels the running job and responds with its state.
+func (s *Server) cancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	j, err := s.jobs.Cancel(mux.Vars(r)["id"])
+	if err != nil {
+		s.writeJobError(w, err)
+		return
+	}
+
+	s.writeJob(w, http.StatusOK, j)
+}
+
+func (s *Server) writeJob(w http.ResponseWriter, status int, j jobs.Job) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(j)
+	if err != nil {
+		s.l.Errorf("cannot write job: %s", err.Error())
+	}
+}
+
+func (s *Server) writeJobError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+	case errors.Is(err, jobs.ErrTooManyJobs), errors.Is(err, jobs.ErrClosed):
+		s.l.Warnf("cannot submit job: %s", err.Error())
+	default:
+		s.l.Errorf("cannot handle job: %s", err.Error())
+	}
+
+	s.writeError(w, err)
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Snyssfx/container_scheduler/internal/api/mock"
+	"github.com/Snyssfx/container_scheduler/internal/jobs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestServer_submitJobHandler(t *testing.T) {
+	jm := mock.NewJobManagerMock(t)
+	jm.SubmitMock.Set(func(image string, seed int, input int) (j1 jobs.Job, err error) {
+		if seed == 2 {
+			return jobs.Job{}, jobs.ErrTooManyJobs
+		}
+		if seed == 3 {
+			return jobs.Job{}, jobs.ErrClosed
+		}
+		return jobs.Job{ID: "ab12", Image: image, Seed: seed, Input: input, Status: jobs.StatusRunning}, nil
+	})
+	s := NewServer(zap.NewNop().Sugar(), mock.NewContainersMapMock(t), jm, Config{DefaultImage: "qual-2021"})
+
+	for body, want := range map[string]int{
+		`{"seed": 1, "input": 3}`:                   http.StatusAccepted,
+		`{"image": "other", "seed": 1, "input": 3}`: http.StatusAccepted,
+		`{"seed": 2, "input": 3}`:                   http.StatusServiceUnavailable,
+		`{"seed": 3, "input": 3}`:                   http.StatusServiceUnavailable,
+		`{"seed": 1}`:                               http.StatusBadRequest,
+		`{"seed": -1, "input": 3}`:                  http.StatusBadRequest,
+		`{"image": "Bad!", "seed": 1, "input": 3}`:  http.StatusBadRequest,
+		`{"seed": 1, "input": 3, "extra": true}`:    http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(body)))
+		assert.Equal(t, want, w.Code, body)
+		if want == http.StatusServiceUnavailable {
+			assert.Equal(t, "1", w.Header().Get("Retry-After"), body)
+		}
+
+		if w.Code == http.StatusAccepted {
+			var j jobs.Job
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &j))
+			assert.Equal(t, "/jobs/ab12", w.Header().Get("Location"))
+			assert.Equal(t, jobs.StatusRunning, j.Status)
+			assert.NotEmpty(t, j.Image)
+		}
+	}
+}
+
+func TestServer_getJobHandler(t *testing.T) {
+	result := 42
+	jm := mock.NewJobManagerMock(t)
+	jm.GetMock.Set(func(id string) (j1 jobs.Job, err error) {
+		if id != "ab12" {
+			return jobs.Job{}, jobs.ErrNotFound
+		}
+		return jobs.Job{ID: id, Status: jobs.StatusDone, Result: &result}, nil
+	})
+	jm.CancelMock.Set(func(id string) (j1 jobs.Job, err error) {
+		if id != "ab12" {
+			return jobs.Job{}, jobs.ErrNotFound
+		}
+		return jobs.Job{ID: id, Status: jobs.StatusCanceled}, nil
+	})
+	s := NewServer(zap.NewNop().Sugar(), mock.NewContainersMapMock(t), jm, Config{DefaultImage: "qual-2021"})
+
+	w := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/jobs/ab12", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"id":"ab12","image":"","seed":0,"input":0,"status":"done","result":42,"created_at":"0001-01-01T00:00:00Z"}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/jobs/ab12", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"canceled"`)
+
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		w = httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(w, httptest.NewRequest(method, "/jobs/cd34", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code, method)
+	}
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Snyssfx/container_scheduler/internal/api/mock"
+	"github.com/Snyssfx/container_scheduler/internal/metrics"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestServer_instrument(t *testing.T) {
+	cm := mock.NewContainersMapMock(t)
+	cm.CalculateMock.Set(func(ctx context.Context, image string, seed int, input int) (i1 int, err error) {
+		if input == 0 {
+			return 0, errors.New("container failed")
+		}
+		return input, nil
+	})
+
+	s := &Server{l: zap.NewNop().Sugar(), containersMap: cm}
+	router := mux.NewRouter()
+	router.HandleFunc("/calculate/{seed}/{user_input}", s.instrument(s.calculateHandler))
+
+	okBefore := testutil.ToFloat64(metrics.Requests.WithLabelValues("200"))
+	errBefore := testutil.ToFloat64(metrics.Requests.WithLabelValues("500"))
+
+	for _, path := range []string{"/calculate/1/1", "/calculate/1/2", "/calculate/1/0"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	assert.Equal(t, okBefore+2, testutil.ToFloat64(metrics.Requests.WithLabelValues("200")))
+	assert.Equal(t, errBefore+1, testutil.ToFloat64(metrics.Requests.WithLabelValues("500")))
+}
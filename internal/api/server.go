@@ -1,51 +1,179 @@
 //go:generate minimock -i containersMap -o ./mock/ -s ".go" -g
+//go:generate minimock -i jobManager -o ./mock/ -s ".go" -g
 
 package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/Snyssfx/container_scheduler/internal/containers"
+	"github.com/Snyssfx/container_scheduler/internal/jobs"
+	"github.com/Snyssfx/container_scheduler/internal/snapshot"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 )
 
+// imagePattern matches names of images in routes.
+const imagePattern = "{image:" + containers.NamePattern + "}"
+
 type Server struct {
-	l             *zap.SugaredLogger
-	port          int
-	server        *http.Server
-	containersMap containersMap
+	l      *zap.SugaredLogger
+	server *http.Server
+	// admin serves admin routes on a separate address that is not exposed to users.
+	admin            *http.Server
+	containersMap    containersMap
+	jobs             jobManager
+	defaultImage     string
+	maxBatchSize     int
+	batchConcurrency int
+	shutdownTimeout  time.Duration
+}
+
+// Config holds settings of a Server.
+type Config struct {
+	Port int
+	// AdminAddr is a host and a port of admin routes, they can stop, warm and pin any seed,
+	// so the address should be reachable by operators only.
+	AdminAddr string
+	// DefaultImage serves routes without an image.
+	DefaultImage string
+	// MaxBatchSize is a maximum count of inputs in one batch request.
+	MaxBatchSize int
+	// BatchConcurrency is a maximum count of simultaneous calculations of one batch request.
+	BatchConcurrency int
+	// ShutdownTimeout is a maximum time to wait for requests in flight on shutdown.
+	ShutdownTimeout time.Duration
+}
+
+// Validate checks that the config is consistent.
+func (cfg Config) Validate() error {
+	if cfg.Port <= 0 || cfg.Port > 65535 {
+		return fmt.Errorf("invalid port %d", cfg.Port)
+	}
+	if cfg.AdminAddr == "" {
+		return fmt.Errorf("empty admin address")
+	}
+	if cfg.DefaultImage == "" {
+		return fmt.Errorf("empty default image")
+	}
+	if cfg.MaxBatchSize < 1 || cfg.BatchConcurrency < 1 {
+		return fmt.Errorf(
+			"max batch size %d and batch concurrency %d should be positive",
+			cfg.MaxBatchSize, cfg.BatchConcurrency,
+		)
+	}
+	if cfg.ShutdownTimeout < 0 {
+		return fmt.Errorf("negative shutdown timeout: %s", cfg.ShutdownTimeout)
+	}
+
+	return nil
 }
 
 type containersMap interface {
-	Calculate(ctx context.Context, seed, input int) (int, error)
+	Calculate(ctx context.Context, image string, seed, input int) (int, error)
+	Warm(ctx context.Context, image string, seed int) error
+	Pin(ctx context.Context, image string, seed int) error
+	Unpin(image string, seed int) error
+	SetIdleTimeout(image string, seed int, idle time.Duration)
+	Status() []snapshot.Seed
+	Evict(image string, seed int) error
+}
+
+type jobManager interface {
+	Submit(image string, seed, input int) (jobs.Job, error)
+	Get(id string) (jobs.Job, error)
+	Cancel(id string) (jobs.Job, error)
 }
 
 // NewServer creates new Server.
-func NewServer(logger *zap.SugaredLogger, containersMap containersMap, port int) *Server {
-	return &Server{
-		l:             logger,
-		port:          port,
-		server:        nil,
-		containersMap: containersMap,
+func NewServer(logger *zap.SugaredLogger, containersMap containersMap, jobs jobManager, cfg Config) *Server {
+	s := &Server{
+		l:                logger,
+		containersMap:    containersMap,
+		jobs:             jobs,
+		defaultImage:     cfg.DefaultImage,
+		maxBatchSize:     cfg.MaxBatchSize,
+		batchConcurrency: cfg.BatchConcurrency,
+		shutdownTimeout:  cfg.ShutdownTimeout,
 	}
-}
 
-// Serve starts the Server.
-func (s *Server) Serve() {
 	r := mux.NewRouter()
-	r.HandleFunc("/calculate/{seed:[0-9]+}/{user_input:[0-9]+}", s.calculateHandler)
+	r.HandleFunc("/calculate/{seed:[0-9]+}/{user_input:[0-9]+}", s.instrument(s.calculateHandler))
+	r.HandleFunc("/calculate/{seed:[0-9]+}/{user_input:[0-9]+}/events", s.instrument(s.streamHandler))
+	r.HandleFunc("/calculate/{seed:[0-9]+}", s.instrument(s.batchHandler))
+	r.HandleFunc("/calculate/"+imagePattern+"/{seed:[0-9]+}/{user_input:[0-9]+}", s.instrument(s.calculateHandler))
+	r.HandleFunc("/calculate/"+imagePattern+"/{seed:[0-9]+}/{user_input:[0-9]+}/events", s.instrument(s.streamHandler))
+	r.HandleFunc("/calculate/"+imagePattern+"/{seed:[0-9]+}", s.instrument(s.batchHandler))
+	r.HandleFunc("/jobs", s.instrument(s.submitJobHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/jobs/{id:[0-9a-f]+}", s.instrument(s.getJobHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/jobs/{id:[0-9a-f]+}", s.instrument(s.cancelJobHandler)).Methods(http.MethodDelete)
+	r.Handle("/metrics", promhttp.Handler())
 
-	s.server = &http.Server{Addr: fmt.Sprintf("0.0.0.0:%d", s.port), Handler: r}
+	admin := mux.NewRouter()
+	admin.HandleFunc("/admin/seeds", s.instrument(s.seedsHandler)).Methods(http.MethodGet)
+	for _, prefix := range []string{"/admin/seeds/{seed:[0-9]+}", "/admin/seeds/" + imagePattern + "/{seed:[0-9]+}"} {
+		admin.HandleFunc(prefix, s.instrument(s.evictHandler)).Methods(http.MethodDelete)
+		admin.HandleFunc(prefix+"/warm", s.instrument(s.warmHandler)).Methods(http.MethodPost)
+		admin.HandleFunc(prefix+"/pin", s.instrument(s.pinHandler)).Methods(http.MethodPut)
+		admin.HandleFunc(prefix+"/pin", s.instrument(s.unpinHandler)).Methods(http.MethodDelete)
+		admin.HandleFunc(prefix+"/idle-timeout", s.instrument(s.setIdleTimeoutHandler)).Methods(http.MethodPut)
+		admin.HandleFunc(prefix+"/idle-timeout", s.instrument(s.resetIdleTimeoutHandler)).Methods(http.MethodDelete)
+	}
+
+	s.server = &http.Server{Addr: fmt.Sprintf("0.0.0.0:%d", cfg.Port), Handler: r}
+	s.admin = &http.Server{Addr: cfg.AdminAddr, Handler: admin}
+	return s
+}
+
+// Serve starts the main and the admin servers.
+func (s *Server) Serve() {
+	go func() {
+		err := s.admin.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.l.Errorf("cannot serve admin server: %s", err.Error())
+		}
+	}()
 
 	err := s.server.ListenAndServe()
-	if err != nil {
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		s.l.Errorf("cannot serve main server: %s", err.Error())
 	}
 }
 
-// Close should be called before shutdown.
-func (s *Server) Close() error {
-	return s.server.Close()
+// image returns the image of the route or the default one.
+func (s *Server) image(vars map[string]string) string {
+	if image, ok := vars["image"]; ok {
+		return image
+	}
+
+	return s.defaultImage
+}
+
+// Shutdown stops accepting new requests and waits for requests in flight during
+// the shutdown timeout, then it closes remaining connections. The admin server is
+// shut down after the main one within the same timeout.
+func (s *Server) Shutdown() error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancelFn()
+
+	err := s.server.Shutdown(ctx)
+	if err == nil {
+		s.l.Infof("main server drained")
+	} else {
+		s.l.Warnf("main server was not drained in %s: %s", s.shutdownTimeout, err.Error())
+		err = multierr.Append(err, s.server.Close())
+	}
+
+	errAdmin := s.admin.Shutdown(ctx)
+	if errAdmin != nil {
+		errAdmin = multierr.Append(fmt.Errorf("cannot drain admin server: %w", errAdmin), s.admin.Close())
+	}
+
+	return multierr.Append(err, errAdmin)
 }
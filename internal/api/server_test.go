@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Snyssfx/container_scheduler/internal/api/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestServer_Shutdown(t *testing.T) {
+	for name, tc := range map[string]struct {
+		timeout time.Duration
+		release bool
+	}{
+		"drained":  {timeout: time.Second, release: true},
+		"deadline": {timeout: 50 * time.Millisecond, release: false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			started := make(chan struct{})
+			release := make(chan struct{})
+			cm := mock.NewContainersMapMock(t)
+			cm.CalculateMock.Set(func(ctx context.Context, image string, seed int, input int) (i1 int, err error) {
+				close(started)
+				select {
+				case <-release:
+					return 42, nil
+				case <-ctx.Done():
+					return 0, ctx.Err()
+				}
+			})
+
+			s := NewServer(zap.NewNop().Sugar(), cm, mock.NewJobManagerMock(t), Config{
+				DefaultImage: "qual-2021", MaxBatchSize: 1, BatchConcurrency: 1, ShutdownTimeout: tc.timeout,
+			})
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			go func() { _ = s.server.Serve(l) }()
+
+			respCh := make(chan string, 1)
+			go func() {
+				resp, err := http.Get(fmt.Sprintf("http://%s/calculate/1/2", l.Addr()))
+				if err != nil {
+					respCh <- err.Error()
+					return
+				}
+				defer resp.Body.Close()
+				b, _ := io.ReadAll(resp.Body)
+				respCh <- string(b)
+			}()
+			<-started
+
+			shutdownCh := make(chan error, 1)
+			go func() { shutdownCh <- s.Shutdown() }()
+
+			if !tc.release {
+				assert.Error(t, <-shutdownCh)
+				close(release)
+				return
+			}
+
+			select {
+			case <-shutdownCh:
+				t.Fatal("shutdown should wait for the request in flight")
+			case <-time.After(50 * time.Millisecond):
+			}
+			close(release)
+
+			assert.NoError(t, <-shutdownCh)
+			assert.Equal(t, "42", <-respCh)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Snyssfx/container_scheduler/internal/progress"
+	"github.com/gorilla/mux"
+)
+
+// streamBuffer is a count of progress events that wait for writing, newer ones are dropped.
+const streamBuffer = 64
+
+// streamHandler calculates the input and streams its progress as Server-Sent Events:
+// queued positions, container start and health checks, the calculation, and the final
+// done or error event.
+func (s *Server) streamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.l.Errorf("streaming is not supported by the response writer")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(r)
+	seed, err := strconv.Atoi(vars["seed"])
+	if err != nil {
+		s.l.Errorf("cannot parse seed: %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	input, err := strconv.Atoi(vars["user_input"])
+	if err != nil {
+		s.l.Errorf("cannot parse input: %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	events := make(chan progress.Event, streamBuffer)
+	ctx := progress.WithReporter(r.Context(), func(e progress.Event) {
+		select {
+		case events <- e:
+		default: // the client is slow, it gets the next events
+		}
+	})
+
+	final := make(chan progress.Event, 1)
+	go func() {
+		result, err := s.containersMap.Calculate(ctx, s.image(vars), seed, input)
+		if err != nil {
+			final <- progress.Event{Stage: progress.StageError, Error: err.Error(), Status: errorStatus(err)}
+			return
+		}
+		final <- progress.Event{Stage: progress.StageDone, Result: &result}
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case e := <-events:
+			s.writeEvent(w, e)
+		case e := <-final:
+			// events reported before the result go first
+			for len(events) > 0 {
+				s.writeEvent(w, <-events)
+			}
+			s.writeEvent(w, e)
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes the event in the Server-Sent Events format.
+func (s *Server) writeEvent(w http.ResponseWriter, e progress.Event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		s.l.Errorf("cannot marshal event: %s", err.Error())
+		return
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Stage, data)
+	if err != nil {
+		s.l.Debugf("cannot write event: %s", err.Error())
+	}
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Snyssfx/container_scheduler/internal/api/mock"
+	"github.com/Snyssfx/container_scheduler/internal/progress"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestServer_streamHandler(t *testing.T) {
+	cm := mock.NewContainersMapMock(t)
+	cm.CalculateMock.Set(func(ctx context.Context, image string, seed int, input int) (i1 int, err error) {
+		assert.Equal(t, "qual-2021", image)
+		progress.Report(ctx, progress.Event{Stage: progress.StageQueued, Position: 2})
+		progress.Report(ctx, progress.Event{Stage: progress.StageHealthCheck, Attempt: 1})
+		if input == 0 {
+			return 0, errors.New("container failed")
+		}
+		return input * 2, nil
+	})
+	s := NewServer(zap.NewNop().Sugar(), cm, mock.NewJobManagerMock(t), Config{DefaultImage: "qual-2021"})
+
+	w := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/calculate/1/3/events", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.Equal(t, "event: queued\ndata: {\"stage\":\"queued\",\"position\":2}\n\n"+
+		"event: health_check\ndata: {\"stage\":\"health_check\",\"attempt\":1}\n\n"+
+		"event: done\ndata: {\"stage\":\"done\",\"result\":6}\n\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/calculate/qual-2021/1/0/events", nil))
+
+	assert.Contains(t, w.Body.String(), "event: error\ndata: {\"stage\":\"error\",\"error\":\"container failed\",\"status\":500}\n\n")
+}
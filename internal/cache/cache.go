@@ -0,0 +1,306 @@
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+	"unsafe"
+
+	"go.uber.org/zap"
+)
+
+// Policy is a strategy for choosing an entry to evict when the cache is full.
+type Policy string
+
+const (
+	// LRU evicts the least recently used entry.
+	LRU Policy = "lru"
+	// LFU evicts the least frequently used entry.
+	LFU Policy = "lfu"
+	// TTL evicts the oldest entry, entries expire after Config.TTL.
+	TTL Policy = "ttl"
+)
+
+// entryOverhead is a size of the structures the cache allocates for one entry besides
+// its image name: the entry with the policy bookkeeping, its key and pointer in the map
+// and a list element of the lru and ttl policies.
+var entryOverhead = int(unsafe.Sizeof(entry{}) + unsafe.Sizeof(Key{}) + unsafe.Sizeof(&entry{}) + unsafe.Sizeof(list.Element{}))
+
+// Config holds limits and the eviction policy of a Cache.
+// Zero limits mean that there is no limit.
+type Config struct {
+	Policy     Policy
+	MaxEntries int
+	// MaxBytes limits the sum of sizes of entries: entryOverhead and the image name of each,
+	// though entries share image names. Spare capacity of the map is not counted.
+	MaxBytes int
+	// TTL is a lifetime of an entry since it was set, a change applies to all entries.
+	TTL time.Duration
+}
+
+// Validate checks that the config is consistent.
+func (cfg Config) Validate() error {
+	switch cfg.Policy {
+	case LRU, LFU:
+	case TTL:
+		if cfg.TTL <= 0 {
+			return fmt.Errorf("ttl policy needs positive ttl, got %s", cfg.TTL)
+		}
+	default:
+		return fmt.Errorf("unknown cache policy %q", cfg.Policy)
+	}
+
+	if cfg.MaxEntries < 0 {
+		return fmt.Errorf("negative max entries: %d", cfg.MaxEntries)
+	}
+	if cfg.MaxBytes < 0 {
+		return fmt.Errorf("negative max bytes: %d", cfg.MaxBytes)
+	}
+	if cfg.MaxBytes > 0 && cfg.MaxBytes < entryOverhead {
+		return fmt.Errorf("max bytes %d is less than one entry (%d bytes)", cfg.MaxBytes, entryOverhead)
+	}
+	if cfg.TTL < 0 {
+		return fmt.Errorf("negative ttl: %s", cfg.TTL)
+	}
+	// only the ttl policy purges expired entries that are not read again
+	if cfg.Policy != TTL && cfg.TTL > 0 && cfg.MaxEntries == 0 && cfg.MaxBytes == 0 {
+		return fmt.Errorf("ttl with %s policy needs max entries or max bytes", cfg.Policy)
+	}
+
+	return nil
+}
+
+// Key identifies a result of a calculation.
+type Key struct {
+	Image       string
+	Seed, Input int
+}
+
+// Cache is a bounded storage of calculation results shared by all seeds.
+// It is safe for concurrent use.
+type Cache struct {
+	l          *zap.SugaredLogger
+	policyName Policy
+	now        func() time.Time
+
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int
+	ttl        time.Duration
+	// bytes is the sum of sizes of entries.
+	bytes  int
+	items  map[Key]*entry
+	policy policy
+	// seedLens are counts of entries of images and seeds.
+	seedLens map[seedKey]int
+}
+
+type seedKey struct {
+	image string
+	seed  int
+}
+
+type entry struct {
+	key    Key
+	result int
+	// setAt is a time the result was set, the entry expires after the ttl since it.
+	setAt time.Time
+	policyRef
+}
+
+func (e *entry) size() int {
+	return entryOverhead + len(e.key.Image)
+}
+
+// stored is a result with the time it was set.
+type stored struct {
+	result int
+	setAt  time.Time
+}
+
+// New creates new Cache.
+func New(l *zap.SugaredLogger, cfg Config) (*Cache, error) {
+	err := cfg.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache config: %w", err)
+	}
+
+	return &Cache{
+		l:          l,
+		policyName: cfg.Policy,
+		now:        time.Now,
+
+		mu:         sync.Mutex{},
+		maxEntries: cfg.MaxEntries,
+		maxBytes:   cfg.MaxBytes,
+		ttl:        cfg.TTL,
+		items:      make(map[Key]*entry),
+		policy:     newPolicy(cfg.Policy),
+		seedLens:   make(map[seedKey]int),
+	}, nil
+}
+
+// SetConfig changes limits and the ttl of the cache, the policy cannot be changed.
+// Extra entries are evicted at once, the new ttl applies to all entries.
+func (c *Cache) SetConfig(cfg Config) error {
+	err := cfg.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid cache config: %w", err)
+	}
+	if cfg.Policy != c.policyName {
+		return fmt.Errorf("cannot change cache policy from %q to %q without a restart", c.policyName, cfg.Policy)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxEntries = cfg.MaxEntries
+	c.maxBytes = cfg.MaxBytes
+	c.ttl = cfg.TTL
+	c.purgeExpired()
+	c.makeRoom(nil)
+
+	return nil
+}
+
+// Get returns a result for the key if it is present and not expired.
+func (c *Cache) Get(key Key) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return 0, false
+	}
+
+	if c.expired(e) {
+		c.remove(e)
+		return 0, false
+	}
+
+	c.policy.touch(e)
+	return e.result, true
+}
+
+// Set saves the result for the key, evicting entries if the cache is full.
+func (c *Cache) Set(key Key, result int) {
+	c.setAt(key, result, c.now())
+}
+
+// setAt saves the result that was set at the given time, it expires after the ttl
+// since that time. A result that is already expired is not saved.
+func (c *Cache) setAt(key Key, result int, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.expiredAt(at) {
+		return
+	}
+
+	if e, ok := c.items[key]; ok {
+		e.result = result
+		e.setAt = at
+		c.policy.update(e)
+		return
+	}
+
+	e := &entry{key: key, result: result, setAt: at}
+	c.purgeExpired()
+	c.makeRoom(e)
+
+	c.items[key] = e
+	c.bytes += e.size()
+	c.policy.add(e)
+	c.seedLens[seedKey{image: key.Image, seed: key.Seed}]++
+}
+
+// Len returns the count of entries in the cache including expired ones
+// that have not been evicted yet.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.items)
+}
+
+// SeedLen returns the count of entries of the image and the seed including expired ones
+// that have not been evicted yet.
+func (c *Cache) SeedLen(image string, seed int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.seedLens[seedKey{image: image, seed: seed}]
+}
+
+// results returns a copy of the results that are not expired.
+func (c *Cache) results() map[Key]stored {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	results := make(map[Key]stored, len(c.items))
+	for key, e := range c.items {
+		if !c.expired(e) {
+			results[key] = stored{result: e.result, setAt: e.setAt}
+		}
+	}
+
+	return results
+}
+
+// makeRoom evicts entries until the new entry fits in the limits, a nil entry only
+// enforces the limits. It is called under the mutex.
+func (c *Cache) makeRoom(e *entry) {
+	count, size := len(c.items), c.bytes
+	if e != nil {
+		count++
+		size += e.size()
+	}
+
+	for len(c.items) > 0 && (c.maxEntries > 0 && count > c.maxEntries || c.maxBytes > 0 && size > c.maxBytes) {
+		victim := c.policy.victim()
+		c.l.Debugf("evict input %d of seed %d of %s", victim.key.Input, victim.key.Seed, victim.key.Image)
+		count--
+		size -= victim.size()
+		c.remove(victim)
+	}
+}
+
+// purgeExpired removes expired entries from the front of the ttl policy, so keys that are
+// never read again do not stay forever. The policy keeps entries in the order they were set
+// and all of them live for the same ttl, so expired entries are at the front. Other policies
+// remove expired entries on reads and evictions. It is called under the mutex.
+func (c *Cache) purgeExpired() {
+	if c.policyName != TTL {
+		return
+	}
+
+	for len(c.items) > 0 {
+		victim := c.policy.victim()
+		if !c.expired(victim) {
+			return
+		}
+		c.remove(victim)
+	}
+}
+
+func (c *Cache) remove(e *entry) {
+	c.policy.remove(e)
+	delete(c.items, e.key)
+	c.bytes -= e.size()
+
+	sk := seedKey{image: e.key.Image, seed: e.key.Seed}
+	c.seedLens[sk]--
+	if c.seedLens[sk] == 0 {
+		delete(c.seedLens, sk)
+	}
+}
+
+// expiredAt reports whether an entry set at the time is expired. It is called under the mutex.
+func (c *Cache) expiredAt(setAt time.Time) bool {
+	return c.ttl > 0 && !c.now().Before(setAt.Add(c.ttl))
+}
+
+func (c *Cache) expired(e *entry) bool {
+	return c.expiredAt(e.setAt)
+}
@@ -0,0 +1,187 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestCache_LRU(t *testing.T) {
+	c := newTestCache(t, Config{Policy: LRU, MaxEntries: 2})
+
+	c.Set(Key{Seed: 1, Input: 1}, 10)
+	c.Set(Key{Seed: 1, Input: 2}, 20)
+	_, ok := c.Get(Key{Seed: 1, Input: 1})
+	require.True(t, ok)
+	c.Set(Key{Seed: 1, Input: 3}, 30)
+
+	assertKeys(t, c, []Key{{Seed: 1, Input: 1}, {Seed: 1, Input: 3}}, []Key{{Seed: 1, Input: 2}})
+}
+
+func TestCache_LFU(t *testing.T) {
+	c := newTestCache(t, Config{Policy: LFU, MaxEntries: 2})
+
+	c.Set(Key{Seed: 1, Input: 1}, 10)
+	c.Set(Key{Seed: 1, Input: 2}, 20)
+	for i := 0; i < 3; i++ {
+		_, ok := c.Get(Key{Seed: 1, Input: 1})
+		require.True(t, ok)
+	}
+	_, ok := c.Get(Key{Seed: 1, Input: 2})
+	require.True(t, ok)
+	c.Set(Key{Seed: 1, Input: 3}, 30)
+
+	assertKeys(t, c, []Key{{Seed: 1, Input: 1}, {Seed: 1, Input: 3}}, []Key{{Seed: 1, Input: 2}})
+}
+
+func TestCache_TTL(t *testing.T) {
+	c := newTestCache(t, Config{Policy: TTL, MaxEntries: 2, TTL: time.Minute})
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.Set(Key{Seed: 1, Input: 1}, 10)
+	now = now.Add(30 * time.Second)
+	c.Set(Key{Seed: 1, Input: 2}, 20)
+	_, ok := c.Get(Key{Seed: 1, Input: 1})
+	require.True(t, ok)
+
+	now = now.Add(31 * time.Second)
+	assertKeys(t, c, []Key{{Seed: 1, Input: 2}}, []Key{{Seed: 1, Input: 1}})
+	assert.Equal(t, 1, c.Len())
+
+	c.Set(Key{Seed: 1, Input: 3}, 30)
+	c.Set(Key{Seed: 1, Input: 4}, 40)
+	assertKeys(t, c, []Key{{Seed: 1, Input: 3}, {Seed: 1, Input: 4}}, []Key{{Seed: 1, Input: 2}})
+}
+
+func TestCache_TTL_Unbounded(t *testing.T) {
+	c := newTestCache(t, Config{Policy: TTL, TTL: time.Minute})
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	// keys that are never read again are purged by later writes
+	for input := 0; input < 100; input++ {
+		c.Set(Key{Seed: 1, Input: input}, input*10)
+		now = now.Add(time.Second)
+	}
+
+	assert.Equal(t, 60, c.Len())
+	assertKeys(t, c, []Key{{Seed: 1, Input: 41}, {Seed: 1, Input: 99}}, []Key{{Seed: 1, Input: 39}})
+}
+
+func TestCache_MaxBytes(t *testing.T) {
+	c := newTestCache(t, Config{Policy: LRU, MaxEntries: 100, MaxBytes: 3 * entryOverhead})
+
+	for i := 0; i < 10; i++ {
+		c.Set(Key{Seed: i, Input: i}, i*10)
+	}
+
+	assert.Equal(t, 3, c.Len())
+	assert.Equal(t, 3*entryOverhead, c.bytes)
+	assertKeys(t, c, []Key{{Seed: 7, Input: 7}, {Seed: 8, Input: 8}, {Seed: 9, Input: 9}}, []Key{{Seed: 6, Input: 6}})
+
+	// image names are counted, so fewer entries with long names fit
+	image := string(make([]byte, entryOverhead))
+	c.Set(Key{Image: image, Seed: 1}, 0)
+	assert.Equal(t, 2, c.Len())
+	assert.LessOrEqual(t, c.bytes, 3*entryOverhead)
+	assertKeys(t, c, []Key{{Image: image, Seed: 1}, {Seed: 9, Input: 9}}, []Key{{Seed: 8, Input: 8}})
+}
+
+func TestCache_SetConfig_TTL(t *testing.T) {
+	c := newTestCache(t, Config{Policy: TTL, TTL: time.Hour})
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.Set(Key{Seed: 1, Input: 1}, 10)
+	now = now.Add(30 * time.Minute)
+	c.Set(Key{Seed: 1, Input: 2}, 20)
+	_, ok := c.Get(Key{Seed: 1, Input: 1})
+	require.True(t, ok)
+
+	// a lower ttl applies to entries that are already set
+	require.NoError(t, c.SetConfig(Config{Policy: TTL, TTL: 10 * time.Minute}))
+	assert.Equal(t, 1, c.Len())
+	assertKeys(t, c, []Key{{Seed: 1, Input: 2}}, []Key{{Seed: 1, Input: 1}})
+
+	c.Set(Key{Seed: 1, Input: 3}, 30)
+	require.NoError(t, c.SetConfig(Config{Policy: TTL, TTL: time.Hour}))
+	now = now.Add(30 * time.Minute)
+	assertKeys(t, c, []Key{{Seed: 1, Input: 3}}, nil)
+}
+
+func TestCache_SetConfig(t *testing.T) {
+	c := newTestCache(t, Config{Policy: LRU, MaxEntries: 3})
+	for input := 1; input <= 3; input++ {
+		c.Set(Key{Seed: 1, Input: input}, input*10)
+	}
+
+	require.Error(t, c.SetConfig(Config{Policy: LFU, MaxEntries: 1}))
+	require.Error(t, c.SetConfig(Config{Policy: LRU, MaxEntries: -1}))
+	assert.Equal(t, 3, c.Len())
+
+	require.NoError(t, c.SetConfig(Config{Policy: LRU, MaxEntries: 1}))
+	assertKeys(t, c, []Key{{Seed: 1, Input: 3}}, []Key{{Seed: 1, Input: 1}, {Seed: 1, Input: 2}})
+
+	c.Set(Key{Seed: 1, Input: 4}, 40)
+	assertKeys(t, c, []Key{{Seed: 1, Input: 4}}, []Key{{Seed: 1, Input: 3}})
+
+	require.NoError(t, c.SetConfig(Config{Policy: LRU}))
+	c.Set(Key{Seed: 1, Input: 5}, 50)
+	assert.Equal(t, 2, c.Len())
+}
+
+func TestCache_SeedLen(t *testing.T) {
+	c := newTestCache(t, Config{Policy: LRU, MaxEntries: 3})
+
+	c.Set(Key{Image: "qual-2021", Seed: 1, Input: 1}, 10)
+	c.Set(Key{Image: "qual-2021", Seed: 1, Input: 1}, 11)
+	c.Set(Key{Image: "qual-2021", Seed: 1, Input: 2}, 20)
+	c.Set(Key{Image: "qual-2021", Seed: 2, Input: 1}, 10)
+	assert.Equal(t, 2, c.SeedLen("qual-2021", 1))
+
+	c.Set(Key{Image: "other", Seed: 1, Input: 1}, 10)
+	c.Set(Key{Image: "other", Seed: 1, Input: 2}, 10)
+	assert.Equal(t, 0, c.SeedLen("qual-2021", 1))
+	assert.Equal(t, 1, c.SeedLen("qual-2021", 2))
+	assert.Equal(t, 2, c.SeedLen("other", 1))
+	assert.Len(t, c.seedLens, 2)
+}
+
+func TestConfig_Validate(t *testing.T) {
+	require.NoError(t, Config{Policy: LFU}.Validate())
+	require.Error(t, Config{Policy: "mru"}.Validate())
+	require.Error(t, Config{Policy: TTL}.Validate())
+	require.Error(t, Config{Policy: LRU, MaxEntries: -1}.Validate())
+	require.Error(t, Config{Policy: LRU, MaxBytes: 1}.Validate())
+	require.NoError(t, Config{Policy: TTL, TTL: time.Minute}.Validate())
+	require.Error(t, Config{Policy: LRU, TTL: time.Minute}.Validate(), "expired entries of lru are removed on reads only")
+	require.NoError(t, Config{Policy: LRU, MaxEntries: 1, TTL: time.Minute}.Validate())
+}
+
+func newTestCache(t *testing.T, cfg Config) *Cache {
+	t.Helper()
+
+	c, err := New(zap.NewNop().Sugar(), cfg)
+	require.NoError(t, err)
+
+	return c
+}
+
+func assertKeys(t *testing.T, c *Cache, present, absent []Key) {
+	t.Helper()
+
+	for _, k := range present {
+		res, ok := c.Get(k)
+		assert.True(t, ok, "key %v should be present", k)
+		assert.Equal(t, k.Input*10, res)
+	}
+
+	for _, k := range absent {
+		_, ok := c.Get(k)
+		assert.False(t, ok, "key %v should be absent", k)
+	}
+}
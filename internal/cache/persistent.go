@@ -0,0 +1,372 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// logMagic is a header of a log file with the format version.
+	logMagic = "CSCACHE1"
+	// valueSize is a size of seed, input, result and the time the result was set as int64.
+	valueSize = 4 * 8
+	// minCompactRecords is a count of records before that the log is never compacted.
+	minCompactRecords = 1024
+)
+
+// Persistent is a Cache backed by an append-only log on disk, so results survive restarts.
+// The log mirrors the memory Cache: results are read only from it, and compaction keeps
+// only its live results, so the limits and the ttl of the memory Cache apply to the log too.
+// Records keep the time results were set, so results that expired while the service was
+// down are not loaded. It is safe for concurrent use.
+type Persistent struct {
+	l    *zap.SugaredLogger
+	mem  *Cache
+	path string
+
+	mu      sync.Mutex
+	f       *os.File
+	records int
+	// closed is true after Close, results are kept in memory only then.
+	closed bool
+	// compacting is true while the log is rewritten in the background, records appended
+	// meanwhile are kept in pending to be copied to the new log.
+	compacting bool
+	pending    [][]byte
+	compaction sync.WaitGroup
+}
+
+// OpenPersistent opens or creates the log at the path and loads it into the memory cache.
+// A corrupted tail of the log is truncated, a log with a bad header is moved aside.
+func OpenPersistent(l *zap.SugaredLogger, path string, mem *Cache) (*Persistent, error) {
+	p := &Persistent{
+		l:    l,
+		mem:  mem,
+		path: path,
+
+		mu: sync.Mutex{},
+	}
+
+	err := p.load()
+	if err != nil {
+		return nil, fmt.Errorf("cannot load cache log %q: %w", path, err)
+	}
+
+	if p.needsCompaction() {
+		p.compacting = true
+		err = p.compact()
+		if err != nil {
+			_ = p.f.Close()
+			return nil, fmt.Errorf("cannot compact cache log %q: %w", path, err)
+		}
+	}
+
+	l.Infof("loaded %d results from %s", mem.Len(), path)
+	return p, nil
+}
+
+// Get returns a result from the memory cache.
+func (p *Persistent) Get(key Key) (int, bool) {
+	return p.mem.Get(key)
+}
+
+// Set saves the result to the memory cache and appends it to the log.
+// The log is compacted in the background when most of its records are stale.
+// After Close the result is saved to the memory cache only.
+func (p *Persistent) Set(key Key, result int) {
+	at := p.mem.now()
+	p.mem.setAt(key, result, at)
+
+	record := encodeRecord(key, stored{result: result, setAt: at})
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return
+	}
+
+	_, err := p.f.Write(record)
+	if err != nil {
+		p.l.Errorf("cannot append result to %s: %s", p.path, err.Error())
+		return
+	}
+	p.records++
+
+	if p.compacting {
+		p.pending = append(p.pending, record)
+		return
+	}
+
+	if p.needsCompaction() {
+		p.compacting = true
+		p.compaction.Add(1)
+		go func() {
+			defer p.compaction.Done()
+
+			errCompact := p.compact()
+			if errCompact != nil {
+				p.l.Errorf("cannot compact %s: %s", p.path, errCompact.Error())
+			}
+		}()
+	}
+}
+
+// SeedLen returns the count of results of the image and the seed in the memory cache.
+func (p *Persistent) SeedLen(image string, seed int) int {
+	return p.mem.SeedLen(image, seed)
+}
+
+// Close waits for a compaction, flushes the log to disk and closes it.
+// Repeated calls do nothing.
+func (p *Persistent) Close() error {
+	p.mu.Lock()
+	closed := p.closed
+	p.closed = true
+	p.mu.Unlock()
+	if closed {
+		return nil
+	}
+
+	// no compaction starts after closed is set
+	p.compaction.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	err := p.f.Sync()
+	if err != nil {
+		return fmt.Errorf("cannot sync cache log: %w", err)
+	}
+
+	return p.f.Close()
+}
+
+// load reads the log into the memory cache and opens it for appending.
+func (p *Persistent) load() error {
+	f, err := os.OpenFile(p.path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("cannot open: %w", err)
+	}
+
+	validSize, err := p.readRecords(f)
+	if errors.Is(err, errBadHeader) {
+		_ = f.Close()
+		p.l.Warnf("%s has a bad header, moving it to %s.corrupted", p.path, p.path)
+
+		err = os.Rename(p.path, p.path+".corrupted")
+		if err != nil {
+			return fmt.Errorf("cannot move corrupted log: %w", err)
+		}
+
+		return p.load()
+	}
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("cannot seek: %w", err)
+	}
+
+	if size != validSize {
+		p.l.Warnf("%s has %d corrupted bytes at the end, truncating", p.path, size-validSize)
+
+		err = f.Truncate(validSize)
+		if err != nil {
+			_ = f.Close()
+			return fmt.Errorf("cannot truncate: %w", err)
+		}
+
+		_, err = f.Seek(validSize, io.SeekStart)
+		if err != nil {
+			_ = f.Close()
+			return fmt.Errorf("cannot seek: %w", err)
+		}
+	}
+
+	p.f = f
+	return nil
+}
+
+var errBadHeader = errors.New("bad log header")
+
+// readRecords fills the memory cache and returns the size of the valid prefix of the log.
+// An empty log gets a header.
+func (p *Persistent) readRecords(f *os.File) (int64, error) {
+	r := bufio.NewReader(f)
+
+	header := make([]byte, len(logMagic))
+	n, err := io.ReadFull(r, header)
+	if n == 0 && errors.Is(err, io.EOF) {
+		_, err = f.Write([]byte(logMagic))
+		if err != nil {
+			return 0, fmt.Errorf("cannot write header: %w", err)
+		}
+		return int64(len(logMagic)), nil
+	}
+	if err != nil || string(header) != logMagic {
+		return 0, errBadHeader
+	}
+
+	validSize := int64(len(logMagic))
+	for {
+		key, s, size, ok := readRecord(r)
+		if !ok {
+			// EOF or a torn write at the end.
+			return validSize, nil
+		}
+
+		// expired results are skipped and dropped from the log by the next compaction
+		p.mem.setAt(key, s.result, s.setAt)
+		p.records++
+		validSize += int64(size)
+	}
+}
+
+// needsCompaction is called under the mutex or before the Persistent is shared.
+func (p *Persistent) needsCompaction() bool {
+	return p.records > minCompactRecords && p.records > 2*p.mem.Len()
+}
+
+// compact rewrites the log with the live results of the memory cache in the order they
+// were set, so the ttl policy gets them in order on load. The snapshot is written without the mutex, so Set only waits for records appended during the compaction
+// to be copied and for the files to be swapped. p.compacting must be set by the caller.
+func (p *Persistent) compact() error {
+	results := p.mem.results()
+	keys := make([]Key, 0, len(results))
+	for key := range results {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return results[keys[i]].setAt.Before(results[keys[j]].setAt) })
+
+	tmpPath := p.path + ".tmp"
+	tmp, w, err := p.writeTmp(tmpPath, keys, results)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pending := len(p.pending)
+	for _, record := range p.pending {
+		if err != nil {
+			break
+		}
+		_, err = w.Write(record)
+	}
+	p.compacting = false
+	p.pending = nil
+
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		if tmp != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmpPath)
+		}
+		return fmt.Errorf("cannot write temporary log: %w", err)
+	}
+
+	err = os.Rename(tmpPath, p.path)
+	if err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("cannot replace log: %w", err)
+	}
+
+	_ = p.f.Close()
+	p.f = tmp
+	p.l.Infof("compacted %s from %d to %d records", p.path, p.records, len(results)+pending)
+	p.records = len(results) + pending
+
+	return nil
+}
+
+// writeTmp creates the temporary log with the header and the results of the keys.
+// The returned writer is not flushed.
+func (p *Persistent) writeTmp(tmpPath string, keys []Key, results map[Key]stored) (*os.File, *bufio.Writer, error) {
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot create: %w", err)
+	}
+
+	w := bufio.NewWriter(tmp)
+	_, err = w.WriteString(logMagic)
+	for _, key := range keys {
+		if err != nil {
+			break
+		}
+		_, err = w.Write(encodeRecord(key, results[key]))
+	}
+
+	return tmp, w, err
+}
+
+// encodeRecord returns the length of the image, the image, seed, input, result, the time
+// the result was set in unix nanoseconds and a crc32 of all of them. Image names are validated
+// to fit in the length byte.
+func encodeRecord(key Key, s stored) []byte {
+	b := make([]byte, 1+len(key.Image)+valueSize+4)
+	b[0] = byte(len(key.Image))
+	copy(b[1:], key.Image)
+
+	values := b[1+len(key.Image):]
+	binary.LittleEndian.PutUint64(values[0:], uint64(key.Seed))
+	binary.LittleEndian.PutUint64(values[8:], uint64(key.Input))
+	binary.LittleEndian.PutUint64(values[16:], uint64(s.result))
+	binary.LittleEndian.PutUint64(values[24:], uint64(s.setAt.UnixNano()))
+
+	crcOffset := len(b) - 4
+	binary.LittleEndian.PutUint32(b[crcOffset:], crc32.ChecksumIEEE(b[:crcOffset]))
+
+	return b
+}
+
+// readRecord reads one record, ok is false for EOF or a corrupted record.
+func readRecord(r *bufio.Reader) (key Key, s stored, size int, ok bool) {
+	imageLen, err := r.ReadByte()
+	if err != nil {
+		return Key{}, stored{}, 0, false
+	}
+
+	b := make([]byte, 1+int(imageLen)+valueSize+4)
+	b[0] = imageLen
+	_, err = io.ReadFull(r, b[1:])
+	if err != nil {
+		return Key{}, stored{}, 0, false
+	}
+
+	crcOffset := len(b) - 4
+	if binary.LittleEndian.Uint32(b[crcOffset:]) != crc32.ChecksumIEEE(b[:crcOffset]) {
+		return Key{}, stored{}, 0, false
+	}
+
+	values := b[1+int(imageLen) : crcOffset]
+	key = Key{
+		Image: string(b[1 : 1+int(imageLen)]),
+		Seed:  int(int64(binary.LittleEndian.Uint64(values[0:]))),
+		Input: int(int64(binary.LittleEndian.Uint64(values[8:]))),
+	}
+	s = stored{
+		result: int(int64(binary.LittleEndian.Uint64(values[16:]))),
+		setAt:  time.Unix(0, int64(binary.LittleEndian.Uint64(values[24:]))),
+	}
+
+	return key, s, len(b), true
+}
@@ -0,0 +1,183 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestPersistent_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.log")
+
+	p := openTestPersistent(t, path)
+	p.Set(Key{Image: "qual-2021", Seed: 1, Input: 2}, 3)
+	p.Set(Key{Image: "qual-2021", Seed: -1, Input: 5}, -6)
+	require.NoError(t, p.Close())
+
+	p = openTestPersistent(t, path)
+	defer p.Close()
+
+	res, ok := p.Get(Key{Image: "qual-2021", Seed: 1, Input: 2})
+	require.True(t, ok)
+	assert.Equal(t, 3, res)
+	res, ok = p.Get(Key{Image: "qual-2021", Seed: -1, Input: 5})
+	require.True(t, ok)
+	assert.Equal(t, -6, res)
+	_, ok = p.Get(Key{Image: "qual-2021", Seed: 1, Input: 1})
+	assert.False(t, ok)
+}
+
+func TestPersistent_TornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.log")
+
+	p := openTestPersistent(t, path)
+	p.Set(Key{Image: "qual-2021", Seed: 1, Input: 1}, 1)
+	p.Set(Key{Image: "qual-2021", Seed: 1, Input: 2}, 2)
+	require.NoError(t, p.Close())
+
+	// corrupt the checksum of the last record and append a half of a record.
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	b[len(b)-1] ^= 0xff
+	b = append(b, encodeRecord(Key{Image: "qual-2021", Seed: 1, Input: 3}, stored{result: 3})[:10]...)
+	require.NoError(t, os.WriteFile(path, b, 0o644))
+
+	p = openTestPersistent(t, path)
+	_, ok := p.Get(Key{Image: "qual-2021", Seed: 1, Input: 1})
+	assert.True(t, ok)
+	_, ok = p.Get(Key{Image: "qual-2021", Seed: 1, Input: 2})
+	assert.False(t, ok)
+
+	p.Set(Key{Image: "qual-2021", Seed: 1, Input: 4}, 4)
+	require.NoError(t, p.Close())
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	recordSize := len(encodeRecord(Key{Image: "qual-2021", Seed: 1, Input: 1}, stored{result: 1}))
+	assert.Equal(t, int64(len(logMagic)+2*recordSize), info.Size())
+}
+
+func TestPersistent_BadHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.log")
+	require.NoError(t, os.WriteFile(path, []byte("garbage garbage"), 0o644))
+
+	p := openTestPersistent(t, path)
+	p.Set(Key{Image: "qual-2021", Seed: 1, Input: 1}, 1)
+	require.NoError(t, p.Close())
+
+	_, err := os.Stat(path + ".corrupted")
+	require.NoError(t, err)
+	p = openTestPersistent(t, path)
+	defer p.Close()
+	_, ok := p.Get(Key{Image: "qual-2021", Seed: 1, Input: 1})
+	assert.True(t, ok)
+}
+
+func TestPersistent_Compaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.log")
+
+	p := openTestPersistent(t, path)
+	for i := 0; i < 3*minCompactRecords; i++ {
+		p.Set(Key{Image: "qual-2021", Seed: 1, Input: i % 10}, i)
+	}
+	require.NoError(t, p.Close())
+
+	// compactions run in the background and may lag behind, the rest is compacted on open
+	p = openTestPersistent(t, path)
+	defer p.Close()
+	assert.LessOrEqual(t, p.records, minCompactRecords)
+	last := 3*minCompactRecords - 1
+	res, ok := p.Get(Key{Image: "qual-2021", Seed: 1, Input: last % 10})
+	require.True(t, ok)
+	assert.Equal(t, last, res)
+}
+
+func TestPersistent_Bounded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.log")
+
+	p := openTestPersistentWith(t, path, newTestCache(t, Config{Policy: LRU, MaxEntries: 2}))
+	for i := 0; i < 3*minCompactRecords; i++ {
+		p.Set(Key{Image: "qual-2021", Seed: 1, Input: i}, i)
+	}
+	require.NoError(t, p.Close())
+
+	p = openTestPersistentWith(t, path, newTestCache(t, Config{Policy: LRU, MaxEntries: 2}))
+	defer p.Close()
+	assert.Equal(t, 2, p.mem.Len())
+	assert.LessOrEqual(t, p.records, minCompactRecords)
+	_, ok := p.Get(Key{Image: "qual-2021", Seed: 1, Input: 0})
+	assert.False(t, ok, "evicted results are not read from the log")
+	last := 3*minCompactRecords - 1
+	res, ok := p.Get(Key{Image: "qual-2021", Seed: 1, Input: last})
+	require.True(t, ok)
+	assert.Equal(t, last, res)
+}
+
+func TestPersistent_TTL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.log")
+	now := time.Now()
+	newMem := func() *Cache {
+		mem := newTestCache(t, Config{Policy: TTL, TTL: time.Minute})
+		mem.now = func() time.Time { return now }
+		return mem
+	}
+
+	p := openTestPersistentWith(t, path, newMem())
+	old := Key{Image: "qual-2021", Seed: 1, Input: 1}
+	p.Set(old, 1)
+	now = now.Add(40 * time.Second)
+	fresh := Key{Image: "qual-2021", Seed: 1, Input: 2}
+	p.Set(fresh, 2)
+	require.NoError(t, p.Close())
+
+	// the restart does not prolong results, the old one expires while the service is down
+	now = now.Add(30 * time.Second)
+	p = openTestPersistentWith(t, path, newMem())
+	_, ok := p.Get(old)
+	assert.False(t, ok, "an expired result is not loaded from the log")
+	res, ok := p.Get(fresh)
+	require.True(t, ok)
+	assert.Equal(t, 2, res)
+
+	now = now.Add(time.Minute)
+	_, ok = p.Get(fresh)
+	assert.False(t, ok, "a loaded result expires after the ttl since it was set")
+	require.NoError(t, p.Close())
+}
+
+func TestPersistent_SetAfterClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.log")
+
+	p := openTestPersistent(t, path)
+	require.NoError(t, p.Close())
+	require.NoError(t, p.Close())
+
+	key := Key{Image: "qual-2021", Seed: 1, Input: 1}
+	p.Set(key, 1)
+	_, ok := p.Get(key)
+	assert.True(t, ok, "the result is kept in memory")
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(logMagic)), info.Size())
+}
+
+func openTestPersistent(t *testing.T, path string) *Persistent {
+	t.Helper()
+
+	return openTestPersistentWith(t, path, newTestCache(t, Config{Policy: LRU, MaxEntries: 100}))
+}
+
+func openTestPersistentWith(t *testing.T, path string, mem *Cache) *Persistent {
+	t.Helper()
+
+	p, err := OpenPersistent(zap.NewNop().Sugar(), path, mem)
+	require.NoError(t, err)
+
+	return p
+}
@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+)
+
+// policy orders entries of a Cache and chooses a victim for eviction.
+// It is called under the Cache mutex.
+type policy interface {
+	// add registers a new entry.
+	add(e *entry)
+	// touch registers a read of the entry.
+	touch(e *entry)
+	// update registers a rewrite of the entry.
+	update(e *entry)
+	// remove unregisters the entry.
+	remove(e *entry)
+	// victim returns the entry that should be evicted first.
+	victim() *entry
+}
+
+// policyRef is a bookkeeping of policies inside an entry.
+type policyRef struct {
+	elem  *list.Element
+	freq  int
+	tick  uint64
+	index int
+}
+
+func newPolicy(p Policy) policy {
+	switch p {
+	case LFU:
+		return &lfuPolicy{}
+	case TTL:
+		return &ttlPolicy{l: list.New()}
+	default:
+		return &lruPolicy{l: list.New()}
+	}
+}
+
+// lruPolicy keeps the most recently used entries at the front of the list.
+type lruPolicy struct {
+	l *list.List
+}
+
+func (p *lruPolicy) add(e *entry)    { e.elem = p.l.PushFront(e) }
+func (p *lruPolicy) touch(e *entry)  { p.l.MoveToFront(e.elem) }
+func (p *lruPolicy) update(e *entry) { p.l.MoveToFront(e.elem) }
+func (p *lruPolicy) remove(e *entry) { p.l.Remove(e.elem) }
+func (p *lruPolicy) victim() *entry  { return p.l.Back().Value.(*entry) }
+
+// ttlPolicy keeps entries in the order of expiration, reads do not prolong them.
+type ttlPolicy struct {
+	l *list.List
+}
+
+func (p *ttlPolicy) add(e *entry)    { e.elem = p.l.PushBack(e) }
+func (p *ttlPolicy) touch(*entry)    {}
+func (p *ttlPolicy) update(e *entry) { p.l.MoveToBack(e.elem) }
+func (p *ttlPolicy) remove(e *entry) { p.l.Remove(e.elem) }
+func (p *ttlPolicy) victim() *entry  { return p.l.Front().Value.(*entry) }
+
+// lfuPolicy is a min-heap by use frequency, ties are broken by the last use.
+type lfuPolicy struct {
+	entries []*entry
+	tick    uint64
+}
+
+func (p *lfuPolicy) add(e *entry) {
+	e.freq = 1
+	e.tick = p.nextTick()
+	heap.Push(p, e)
+}
+
+func (p *lfuPolicy) touch(e *entry) {
+	e.freq++
+	e.tick = p.nextTick()
+	heap.Fix(p, e.index)
+}
+
+func (p *lfuPolicy) update(e *entry) { p.touch(e) }
+func (p *lfuPolicy) remove(e *entry) { heap.Remove(p, e.index) }
+func (p *lfuPolicy) victim() *entry  { return p.entries[0] }
+
+func (p *lfuPolicy) nextTick() uint64 {
+	p.tick++
+	return p.tick
+}
+
+// Len, Less, Swap, Push and Pop implement heap.Interface.
+
+func (p *lfuPolicy) Len() int { return len(p.entries) }
+
+func (p *lfuPolicy) Less(i, j int) bool {
+	a, b := p.entries[i], p.entries[j]
+	if a.freq != b.freq {
+		return a.freq < b.freq
+	}
+
+	return a.tick < b.tick
+}
+
+func (p *lfuPolicy) Swap(i, j int) {
+	p.entries[i], p.entries[j] = p.entries[j], p.entries[i]
+	p.entries[i].index = i
+	p.entries[j].index = j
+}
+
+func (p *lfuPolicy) Push(x interface{}) {
+	e := x.(*entry)
+	e.index = len(p.entries)
+	p.entries = append(p.entries, e)
+}
+
+func (p *lfuPolicy) Pop() interface{} {
+	last := len(p.entries) - 1
+	e := p.entries[last]
+	p.entries[last] = nil
+	p.entries = p.entries[:last]
+	return e
+}
@@ -0,0 +1,644 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Snyssfx/container_scheduler/internal/api"
+	"github.com/Snyssfx/container_scheduler/internal/cache"
+	"github.com/Snyssfx/container_scheduler/internal/containers"
+	"github.com/Snyssfx/container_scheduler/internal/containersmap"
+	"github.com/Snyssfx/container_scheduler/internal/deduplicator"
+	"github.com/Snyssfx/container_scheduler/internal/forecast"
+	"github.com/Snyssfx/container_scheduler/internal/jobs"
+	"github.com/Snyssfx/container_scheduler/internal/reconciler"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is a prefix of environment variables, the rest is a flag name in upper case
+// with underscores, e.g. CONTAINER_SCHEDULER_CACHE_MAX_ENTRIES for -cache-max-entries.
+const EnvPrefix = "CONTAINER_SCHEDULER_"
+
+// configFlag is a flag and a variable with a path to the config file.
+const configFlag = "config"
+
+// Config holds all settings of the service.
+type Config struct {
+	LogLevel string `yaml:"log_level"`
+	// Images is a path to a YAML file with image specs, empty means qual-2021 only.
+	Images string `yaml:"images"`
+
+	Server        Server        `yaml:"server"`
+	Cache         Cache         `yaml:"cache"`
+	Docker        Docker        `yaml:"docker"`
+	Containers    Containers    `yaml:"containers"`
+	Deduplicator  Deduplicator  `yaml:"deduplicator"`
+	ContainersMap ContainersMap `yaml:"containers_map"`
+	Jobs          Jobs          `yaml:"jobs"`
+	Forecast      Forecast      `yaml:"forecast"`
+}
+
+// Server holds settings of the HTTP server.
+type Server struct {
+	Port             int           `yaml:"port"`
+	AdminAddr        string        `yaml:"admin_addr"`
+	DefaultImage     string        `yaml:"default_image"`
+	MaxBatchSize     int           `yaml:"max_batch_size"`
+	BatchConcurrency int           `yaml:"batch_concurrency"`
+	ShutdownTimeout  time.Duration `yaml:"shutdown_timeout"`
+}
+
+// Cache holds settings of the results cache.
+type Cache struct {
+	Policy     string        `yaml:"policy"`
+	MaxEntries int           `yaml:"max_entries"`
+	MaxBytes   int           `yaml:"max_bytes"`
+	TTL        time.Duration `yaml:"ttl"`
+	File       string        `yaml:"file"`
+}
+
+// Docker holds settings of the docker runtime.
+type Docker struct {
+	Runtime string `yaml:"runtime"`
+	Socket  string `yaml:"socket"`
+	// Orphans is what to do with containers of a previous run on startup: remove or adopt.
+	Orphans string `yaml:"orphans"`
+}
+
+// Containers holds timeouts of containers.
+type Containers struct {
+	InitializationTimeout time.Duration `yaml:"initialization_timeout"`
+	CalculationTimeout    time.Duration `yaml:"calculation_timeout"`
+	IdleTimeout           time.Duration `yaml:"idle_timeout"`
+	HealthInterval        time.Duration `yaml:"health_interval"`
+	AdaptiveIdle          bool          `yaml:"adaptive_idle"`
+	MaxIdleTimeout        time.Duration `yaml:"max_idle_timeout"`
+	RestartWeight         float64       `yaml:"restart_weight"`
+	Retries               int           `yaml:"retries"`
+	RetryBackoff          time.Duration `yaml:"retry_backoff"`
+	UnhealthyFailures     int           `yaml:"unhealthy_failures"`
+}
+
+// Deduplicator holds settings of calculations of one seed.
+type Deduplicator struct {
+	Replicas    int           `yaml:"replicas"`
+	Concurrency int           `yaml:"concurrency"`
+	Scheduling  string        `yaml:"scheduling"`
+	AgingPeriod time.Duration `yaml:"aging_period"`
+}
+
+// ContainersMap holds limits of running containers.
+type ContainersMap struct {
+	MaxContainers int           `yaml:"max_containers"`
+	MaxWait       time.Duration `yaml:"max_wait"`
+	GCAfter       time.Duration `yaml:"gc_after"`
+	// BreakerFailures is a count of failed calculations of a seed in a row after which its
+	// requests fail fast for BreakerCoolDown, 0 disables breakers.
+	BreakerFailures int           `yaml:"breaker_failures"`
+	BreakerCoolDown time.Duration `yaml:"breaker_cooldown"`
+	// Pinned are seeds that are warmed at startup and kept running, as "seed" of the default
+	// image or "image/seed".
+	Pinned []string `yaml:"pinned,omitempty"`
+	// IdleTimeouts override idle timeouts of seeds, as "seed=duration" or "image/seed=duration".
+	IdleTimeouts []string `yaml:"idle_timeouts,omitempty"`
+}
+
+// Jobs holds settings of background calculations.
+type Jobs struct {
+	TTL     time.Duration `yaml:"ttl"`
+	MaxJobs int           `yaml:"max_jobs"`
+}
+
+// Forecast holds settings of predictive warming.
+type Forecast struct {
+	Enabled bool          `yaml:"enabled"`
+	Alpha   float64       `yaml:"alpha"`
+	Lead    time.Duration `yaml:"lead"`
+}
+
+// Default returns the config that is used when nothing is set. Features that change
+// the behavior of the scheduler, like retries, adaptive idle timeouts, breakers and
+// predictive warming, are off, their other settings are defaults for turning them on.
+func Default() Config {
+	return Config{
+		LogLevel: "debug",
+		Server: Server{
+			Port:             9002,
+			AdminAddr:        "127.0.0.1:9003",
+			DefaultImage:     containers.DefaultImage,
+			MaxBatchSize:     api.DefaultMaxBatchSize,
+			BatchConcurrency: api.DefaultBatchConcurrency,
+			ShutdownTimeout:  30 * time.Second,
+		},
+		Cache: Cache{
+			Policy:     string(cache.LRU),
+			MaxEntries: 1_000_000,
+			MaxBytes:   256 << 20,
+		},
+		Docker: Docker{
+			Runtime: string(containers.RuntimeCLI),
+			Socket:  "/var/run/docker.sock",
+			Orphans: string(reconciler.PolicyRemove),
+		},
+		Containers: Containers{
+			InitializationTimeout: 130 * time.Second,
+			CalculationTimeout:    130 * time.Second,
+			IdleTimeout:           120 * time.Second,
+			HealthInterval:        2 * time.Second,
+			AdaptiveIdle:          false,
+			MaxIdleTimeout:        30 * time.Minute,
+			RestartWeight:         2,
+			Retries:               0,
+			RetryBackoff:          time.Second,
+			UnhealthyFailures:     3,
+		},
+		Deduplicator: Deduplicator{
+			Replicas:    1,
+			Concurrency: 1,
+			Scheduling:  "most-subscribers",
+			AgingPeriod: deduplicator.DefaultAgingPeriod,
+		},
+		ContainersMap: ContainersMap{
+			MaxWait: 30 * time.Second,
+			GCAfter: 30 * time.Minute,
+
+			BreakerFailures: 0,
+			BreakerCoolDown: 30 * time.Second,
+		},
+		Jobs: Jobs{
+			TTL:     10 * time.Minute,
+			MaxJobs: 10_000,
+		},
+		Forecast: Forecast{
+			Enabled: false,
+			Alpha:   0.3,
+			Lead:    2 * time.Minute,
+		},
+	}
+}
+
+// register binds flags to the fields of the config. Current values become defaults of flags.
+func (c *Config) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "a minimal level of logs: debug, info, warn or error")
+	fs.StringVar(&c.Images, "images", c.Images, "a path to a YAML file with image specs, empty means qual-2021 only")
+
+	fs.IntVar(&c.Server.Port, "port", c.Server.Port, "a port that a server should listen for user requests")
+	fs.StringVar(&c.Server.AdminAddr, "admin-addr", c.Server.AdminAddr, "a host and a port of admin routes that can stop, warm and pin any seed, keep it private")
+	fs.StringVar(&c.Server.DefaultImage, "default-image", c.Server.DefaultImage, "an image that serves routes without an image")
+	fs.IntVar(&c.Server.MaxBatchSize, "max-batch-size", c.Server.MaxBatchSize, "a maximum count of inputs in one batch request")
+	fs.IntVar(&c.Server.BatchConcurrency, "batch-concurrency", c.Server.BatchConcurrency, "a maximum count of simultaneous calculations of one batch request")
+	fs.DurationVar(&c.Server.ShutdownTimeout, "shutdown-timeout", c.Server.ShutdownTimeout, "a maximum time to wait for requests in flight on shutdown")
+
+	fs.StringVar(&c.Cache.Policy, "cache-policy", c.Cache.Policy, "an eviction policy of the results cache: lru, lfu or ttl")
+	fs.IntVar(&c.Cache.MaxEntries, "cache-max-entries", c.Cache.MaxEntries, "a maximum count of cached results, 0 means no limit")
+	fs.IntVar(&c.Cache.MaxBytes, "cache-max-bytes", c.Cache.MaxBytes, "a maximum size of cached results in bytes, every result counts its cache structures (176 bytes on 64-bit platforms) and its image name, 0 means no limit")
+	fs.DurationVar(&c.Cache.TTL, "cache-ttl", c.Cache.TTL, "a lifetime of a cached result, 0 means forever")
+	fs.StringVar(&c.Cache.File, "cache-file", c.Cache.File, "a path to the on-disk results log that survives restarts, empty means no persistence")
+
+	fs.StringVar(&c.Docker.Runtime, "docker", c.Docker.Runtime, "a way to control docker: cli or api")
+	fs.StringVar(&c.Docker.Socket, "docker-socket", c.Docker.Socket, "a path to the Docker Engine API socket")
+	fs.StringVar(&c.Docker.Orphans, "orphans", c.Docker.Orphans, "what to do with containers of a previous run on startup: remove or adopt")
+
+	fs.DurationVar(&c.Containers.InitializationTimeout, "init-timeout", c.Containers.InitializationTimeout, "a maximum time for a container to become healthy")
+	fs.DurationVar(&c.Containers.CalculationTimeout, "calculation-timeout", c.Containers.CalculationTimeout, "a maximum time of one calculation request to a container")
+	fs.DurationVar(&c.Containers.IdleTimeout, "idle-timeout", c.Containers.IdleTimeout, "a time after the last calculation when a container is stopped")
+	fs.DurationVar(&c.Containers.HealthInterval, "health-interval", c.Containers.HealthInterval, "a period of health checks of an initializing container")
+	fs.BoolVar(&c.Containers.AdaptiveIdle, "adaptive-idle", c.Containers.AdaptiveIdle, "choose the idle timeout of every container from its initialization duration and gaps between calculations")
+	fs.DurationVar(&c.Containers.MaxIdleTimeout, "max-idle-timeout", c.Containers.MaxIdleTimeout, "the longest adaptive idle timeout")
+	fs.Float64Var(&c.Containers.RestartWeight, "idle-restart-weight", c.Containers.RestartWeight, "a cost of a container restart in its initialization durations of idle time")
+	fs.IntVar(&c.Containers.Retries, "calculation-retries", c.Containers.Retries, "a count of repeated calculations after 5xx statuses or connection errors of a container")
+	fs.DurationVar(&c.Containers.RetryBackoff, "retry-backoff", c.Containers.RetryBackoff, "a pause before the first retry of a calculation, it doubles for the next ones")
+	fs.IntVar(&c.Containers.UnhealthyFailures, "unhealthy-failures", c.Containers.UnhealthyFailures, "a count of failed calculations in a row after which a container is restarted, 0 means never")
+
+	fs.IntVar(&c.Deduplicator.Replicas, "replicas", c.Deduplicator.Replicas, "a count of containers for one seed")
+	fs.IntVar(&c.Deduplicator.Concurrency, "concurrency", c.Deduplicator.Concurrency, "a count of simultaneous calculations in one container")
+	fs.StringVar(&c.Deduplicator.Scheduling, "scheduling", c.Deduplicator.Scheduling, "a policy of choosing the next input: most-subscribers, fifo, aging or deadline")
+	fs.DurationVar(&c.Deduplicator.AgingPeriod, "aging-period", c.Deduplicator.AgingPeriod, "a waiting time that weighs as one more subscriber in the aging policy")
+
+	fs.IntVar(&c.ContainersMap.MaxContainers, "max-containers", c.ContainersMap.MaxContainers, "a maximum count of seeds with a running container, 0 means no limit")
+	fs.DurationVar(&c.ContainersMap.MaxWait, "max-containers-wait", c.ContainersMap.MaxWait, "a maximum time for a new seed to wait for a free container")
+	fs.DurationVar(&c.ContainersMap.GCAfter, "gc-after", c.ContainersMap.GCAfter, "a time after the last request when a seed with stopped containers is removed, 0 means never")
+	fs.IntVar(&c.ContainersMap.BreakerFailures, "breaker-failures", c.ContainersMap.BreakerFailures, "a count of failed calculations of a seed in a row after which its requests fail fast, 0 disables breakers")
+	fs.DurationVar(&c.ContainersMap.BreakerCoolDown, "breaker-cooldown", c.ContainersMap.BreakerCoolDown, "a time of failing fast before a probe request of a failing seed")
+	fs.Var(stringList{&c.ContainersMap.Pinned}, "pinned", "comma-separated seeds to warm at startup and keep running, as seed or image/seed")
+	fs.Var(stringList{&c.ContainersMap.IdleTimeouts}, "idle-timeouts", "comma-separated idle timeouts of seeds, as seed=duration or image/seed=duration")
+
+	fs.DurationVar(&c.Jobs.TTL, "job-ttl", c.Jobs.TTL, "a time a finished job is kept for GET /jobs/{id}")
+	fs.IntVar(&c.Jobs.MaxJobs, "max-jobs", c.Jobs.MaxJobs, "a maximum count of stored jobs, running and finished ones")
+
+	fs.BoolVar(&c.Forecast.Enabled, "forecast", c.Forecast.Enabled, "warm seeds before expected requests")
+	fs.Float64Var(&c.Forecast.Alpha, "forecast-alpha", c.Forecast.Alpha, "a weight of the latest interval between requests of a seed in its moving average")
+	fs.DurationVar(&c.Forecast.Lead, "forecast-lead", c.Forecast.Lead, "how long before an expected request a seed is warmed")
+}
+
+// Load builds the config from defaults, the config file, environment variables and flags,
+// each of them overrides the previous ones. The config file is set by -config or
+// CONTAINER_SCHEDULER_CONFIG.
+func Load(args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	fromFlags := Default()
+	flags := flag.NewFlagSet("container_scheduler", flag.ContinueOnError)
+	fromFlags.register(flags)
+	path := flags.String(configFlag, "", "a path to a YAML config file, see config.example.yaml")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if !isSet(flags, configFlag) {
+		*path, _ = lookupEnv(envName(configFlag))
+	}
+
+	cfg := Default()
+	if *path != "" {
+		cfg, err = readFile(*path)
+		if err != nil {
+			return Config{}, err
+		}
+	}
+
+	merged := flag.NewFlagSet("merged", flag.ContinueOnError)
+	cfg.register(merged)
+
+	merged.VisitAll(func(f *flag.Flag) {
+		value, ok := lookupEnv(envName(f.Name))
+		if !ok || err != nil {
+			return
+		}
+
+		errSet := f.Value.Set(value)
+		if errSet != nil {
+			err = fmt.Errorf("invalid %s=%q: %w", envName(f.Name), value, errSet)
+		}
+	})
+	if err != nil {
+		return Config{}, err
+	}
+
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name != configFlag {
+			_ = merged.Set(f.Name, f.Value.String())
+		}
+	})
+
+	err = cfg.Validate()
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// readFile reads the config file over the defaults, unknown keys are errors.
+func readFile(path string) (Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("cannot read config file: %w", err)
+	}
+
+	cfg := Default()
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+
+	err = dec.Decode(&cfg)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return Config{}, fmt.Errorf("cannot parse config file %q: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+func isSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+
+	return set
+}
+
+func envName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Reloadable returns next with the settings that need a restart taken from c
+// and the names of such settings that are different in next.
+// The server, images, the cache policy, the cache file, the docker runtime and socket, the orphans
+// policy, jobs, pinned seeds, idle timeouts of seeds and the forecast need a restart: running
+// containers and the cleanup on shutdown use the docker of the start.
+func (c Config) Reloadable(next Config) (Config, []string) {
+	var ignored []string
+	if next.Server != c.Server {
+		ignored = append(ignored, "server")
+		next.Server = c.Server
+	}
+	if next.Images != c.Images {
+		ignored = append(ignored, "images")
+		next.Images = c.Images
+	}
+	if next.Cache.Policy != c.Cache.Policy {
+		ignored = append(ignored, "cache.policy")
+		next.Cache.Policy = c.Cache.Policy
+	}
+	if next.Cache.File != c.Cache.File {
+		ignored = append(ignored, "cache.file")
+		next.Cache.File = c.Cache.File
+	}
+	if next.Docker.Runtime != c.Docker.Runtime {
+		ignored = append(ignored, "docker.runtime")
+		next.Docker.Runtime = c.Docker.Runtime
+	}
+	if next.Docker.Socket != c.Docker.Socket {
+		ignored = append(ignored, "docker.socket")
+		next.Docker.Socket = c.Docker.Socket
+	}
+	if next.Docker.Orphans != c.Docker.Orphans {
+		ignored = append(ignored, "docker.orphans")
+		next.Docker.Orphans = c.Docker.Orphans
+	}
+	if strings.Join(next.ContainersMap.Pinned, ",") != strings.Join(c.ContainersMap.Pinned, ",") {
+		ignored = append(ignored, "containers_map.pinned")
+		next.ContainersMap.Pinned = c.ContainersMap.Pinned
+	}
+	if strings.Join(next.ContainersMap.IdleTimeouts, ",") != strings.Join(c.ContainersMap.IdleTimeouts, ",") {
+		ignored = append(ignored, "containers_map.idle_timeouts")
+		next.ContainersMap.IdleTimeouts = c.ContainersMap.IdleTimeouts
+	}
+	if next.Jobs != c.Jobs {
+		ignored = append(ignored, "jobs")
+		next.Jobs = c.Jobs
+	}
+	if next.Forecast != c.Forecast {
+		ignored = append(ignored, "forecast")
+		next.Forecast = c.Forecast
+	}
+
+	return next, ignored
+}
+
+// NewSeedsOnly returns the names of settings that are different in next and are applied only
+// to deduplicators created after a reload: seeds in the map keep their containers, workers and
+// scheduling policy until they are evicted or collected.
+func (c Config) NewSeedsOnly(next Config) []string {
+	var changed []string
+	if next.Deduplicator.Replicas != c.Deduplicator.Replicas {
+		changed = append(changed, "deduplicator.replicas")
+	}
+	if next.Deduplicator.Concurrency != c.Deduplicator.Concurrency {
+		changed = append(changed, "deduplicator.concurrency")
+	}
+	if next.Deduplicator.Scheduling != c.Deduplicator.Scheduling {
+		changed = append(changed, "deduplicator.scheduling")
+	}
+	if next.Deduplicator.AgingPeriod != c.Deduplicator.AgingPeriod {
+		changed = append(changed, "deduplicator.aging_period")
+	}
+
+	return changed
+}
+
+// Validate checks the config with validations of every component.
+func (c Config) Validate() error {
+	_, err := c.Level()
+	if err != nil {
+		return err
+	}
+
+	err = c.APIConfig().Validate()
+	if err != nil {
+		return fmt.Errorf("invalid server config: %w", err)
+	}
+
+	err = c.CacheConfig().Validate()
+	if err != nil {
+		return fmt.Errorf("invalid cache config: %w", err)
+	}
+
+	_, err = c.SchedulingPolicy()
+	if err != nil {
+		return err
+	}
+
+	err = c.DeduplicatorConfig(nil).Validate()
+	if err != nil {
+		return fmt.Errorf("invalid deduplicator config: %w", err)
+	}
+
+	err = c.ContainersMapConfig().Validate()
+	if err != nil {
+		return fmt.Errorf("invalid containers map config: %w", err)
+	}
+
+	err = c.OrphansPolicy().Validate()
+	if err != nil {
+		return err
+	}
+
+	_, err = c.PinnedSeeds()
+	if err != nil {
+		return err
+	}
+
+	_, err = c.SeedIdleTimeouts()
+	if err != nil {
+		return err
+	}
+
+	err = c.JobsConfig().Validate()
+	if err != nil {
+		return fmt.Errorf("invalid jobs config: %w", err)
+	}
+
+	if c.Forecast.Enabled {
+		err = c.ForecastConfig().Validate()
+		if err != nil {
+			return fmt.Errorf("invalid forecast config: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Level returns the log level.
+func (c Config) Level() (zapcore.Level, error) {
+	var level zapcore.Level
+	err := level.Set(c.LogLevel)
+	if err != nil {
+		return 0, fmt.Errorf("invalid log level %q: %w", c.LogLevel, err)
+	}
+
+	return level, nil
+}
+
+// APIConfig returns settings of api.Server.
+func (c Config) APIConfig() api.Config {
+	return api.Config{
+		Port:             c.Server.Port,
+		AdminAddr:        c.Server.AdminAddr,
+		DefaultImage:     c.Server.DefaultImage,
+		MaxBatchSize:     c.Server.MaxBatchSize,
+		BatchConcurrency: c.Server.BatchConcurrency,
+		ShutdownTimeout:  c.Server.ShutdownTimeout,
+	}
+}
+
+// CacheConfig returns settings of cache.Cache.
+func (c Config) CacheConfig() cache.Config {
+	return cache.Config{
+		Policy:     cache.Policy(c.Cache.Policy),
+		MaxEntries: c.Cache.MaxEntries,
+		MaxBytes:   c.Cache.MaxBytes,
+		TTL:        c.Cache.TTL,
+	}
+}
+
+// SchedulingPolicy returns the policy of deduplicators.
+func (c Config) SchedulingPolicy() (deduplicator.SchedulingPolicy, error) {
+	return deduplicator.NewSchedulingPolicy(c.Deduplicator.Scheduling, c.Deduplicator.AgingPeriod)
+}
+
+// DeduplicatorConfig returns settings of deduplicators with the given scheduling policy.
+func (c Config) DeduplicatorConfig(scheduling deduplicator.SchedulingPolicy) deduplicator.Config {
+	return deduplicator.Config{
+		Replicas:    c.Deduplicator.Replicas,
+		Concurrency: c.Deduplicator.Concurrency,
+		Scheduling:  scheduling,
+		Containers: containers.Config{
+			Runtime:               containers.Runtime(c.Docker.Runtime),
+			DockerSocket:          c.Docker.Socket,
+			InitializationTimeout: c.Containers.InitializationTimeout,
+			CalculationTimeout:    c.Containers.CalculationTimeout,
+			IdleTimeout:           c.Containers.IdleTimeout,
+			HealthInterval:        c.Containers.HealthInterval,
+			AdaptiveIdle:          c.Containers.AdaptiveIdle,
+			MaxIdleTimeout:        c.Containers.MaxIdleTimeout,
+			RestartWeight:         c.Containers.RestartWeight,
+			Retries:               c.Containers.Retries,
+			RetryBackoff:          c.Containers.RetryBackoff,
+			UnhealthyFailures:     c.Containers.UnhealthyFailures,
+		},
+	}
+}
+
+// OrphansPolicy returns the policy of containers of a previous run.
+func (c Config) OrphansPolicy() reconciler.Policy {
+	return reconciler.Policy(c.Docker.Orphans)
+}
+
+// ContainersMapConfig returns limits of containersmap.ContainersMap.
+func (c Config) ContainersMapConfig() containersmap.Config {
+	return containersmap.Config{
+		MaxContainers: c.ContainersMap.MaxContainers,
+		MaxWait:       c.ContainersMap.MaxWait,
+		GCAfter:       c.ContainersMap.GCAfter,
+
+		BreakerFailures: c.ContainersMap.BreakerFailures,
+		BreakerCoolDown: c.ContainersMap.BreakerCoolDown,
+	}
+}
+
+// PinnedSeeds returns keys of pinned seeds, a seed without an image is of the default image.
+func (c Config) PinnedSeeds() ([]containersmap.Key, error) {
+	keys := make([]containersmap.Key, 0, len(c.ContainersMap.Pinned))
+	for _, pinned := range c.ContainersMap.Pinned {
+		key, ok := c.parseKey(pinned)
+		if !ok {
+			return nil, fmt.Errorf("invalid pinned seed %q, want seed or image/seed", pinned)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// SeedIdleTimeouts returns idle timeouts of seeds, a seed without an image is of the default image.
+func (c Config) SeedIdleTimeouts() (map[containersmap.Key]time.Duration, error) {
+	timeouts := make(map[containersmap.Key]time.Duration, len(c.ContainersMap.IdleTimeouts))
+	for _, timeout := range c.ContainersMap.IdleTimeouts {
+		seed, durationStr, _ := strings.Cut(timeout, "=")
+		key, ok := c.parseKey(seed)
+		idle, err := time.ParseDuration(durationStr)
+		if !ok || err != nil || idle <= 0 {
+			return nil, fmt.Errorf("invalid idle timeout %q, want seed=duration or image/seed=duration", timeout)
+		}
+		timeouts[key] = idle
+	}
+
+	return timeouts, nil
+}
+
+// parseKey parses "seed" of the default image or "image/seed".
+func (c Config) parseKey(s string) (containersmap.Key, bool) {
+	image, seedStr := c.Server.DefaultImage, s
+	if i := strings.LastIndex(s, "/"); i >= 0 {
+		image, seedStr = s[:i], s[i+1:]
+	}
+
+	seed, err := strconv.Atoi(seedStr)
+	if err != nil || seed < 0 || image == "" {
+		return containersmap.Key{}, false
+	}
+
+	return containersmap.Key{Image: image, Seed: seed}, true
+}
+
+// JobsConfig returns settings of jobs.Manager.
+func (c Config) JobsConfig() jobs.Config {
+	return jobs.Config{
+		TTL:     c.Jobs.TTL,
+		MaxJobs: c.Jobs.MaxJobs,
+	}
+}
+
+// ForecastConfig returns settings of forecast.Forecaster.
+func (c Config) ForecastConfig() forecast.Config {
+	return forecast.Config{
+		Alpha: c.Forecast.Alpha,
+		Lead:  c.Forecast.Lead,
+	}
+}
+
+// String returns the config in YAML.
+func (c Config) String() string {
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Sprintf("cannot marshal config: %s", err.Error())
+	}
+
+	return string(b)
+}
+
+// stringList is a flag of a comma-separated list.
+type stringList struct {
+	list *[]string
+}
+
+func (s stringList) String() string {
+	if s.list == nil {
+		return ""
+	}
+
+	return strings.Join(*s.list, ",")
+}
+
+func (s stringList) Set(value string) error {
+	*s.list = nil
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			*s.list = append(*s.list, item)
+		}
+	}
+
+	return nil
+}
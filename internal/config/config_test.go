@@ -0,0 +1,200 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Snyssfx/container_scheduler/internal/containersmap"
+	"github.com/Snyssfx/container_scheduler/internal/deduplicator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, err := Load(nil, env(nil))
+
+	require.NoError(t, err)
+	assert.Equal(t, Default(), cfg)
+
+	// new behavior is opt-in
+	policy, err := cfg.SchedulingPolicy()
+	require.NoError(t, err)
+	assert.Equal(t, deduplicator.MostSubscribers{}, policy)
+	assert.False(t, cfg.Forecast.Enabled)
+	assert.False(t, cfg.Containers.AdaptiveIdle)
+	assert.Zero(t, cfg.Containers.Retries)
+	assert.Zero(t, cfg.ContainersMap.BreakerFailures)
+}
+
+func TestLoad_Example(t *testing.T) {
+	cfg, err := Load([]string{"-config", filepath.Join("..", "..", "config.example.yaml")}, env(nil))
+
+	require.NoError(t, err)
+	want := Default()
+	want.Images = "images.example.yaml"
+	assert.Equal(t, want, cfg)
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeConfig(t, `
+server:
+  port: 8000
+containers:
+  idle_timeout: 5m
+  calculation_timeout: 10s
+deduplicator:
+  replicas: 3
+`)
+
+	cfg, err := Load(
+		[]string{"-idle-timeout", "1m", "-replicas", "2"},
+		env(map[string]string{
+			"CONTAINER_SCHEDULER_CONFIG":       path,
+			"CONTAINER_SCHEDULER_IDLE_TIMEOUT": "3m",
+			"CONTAINER_SCHEDULER_PORT":         "8001",
+		}),
+	)
+
+	require.NoError(t, err)
+	assert.Equal(t, 8001, cfg.Server.Port)
+	assert.Equal(t, time.Minute, cfg.Containers.IdleTimeout)
+	assert.Equal(t, 10*time.Second, cfg.Containers.CalculationTimeout)
+	assert.Equal(t, 2, cfg.Deduplicator.Replicas)
+	assert.Equal(t, Default().Containers.HealthInterval, cfg.Containers.HealthInterval)
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	for name, tc := range map[string]struct {
+		args []string
+		env  map[string]string
+		file string
+	}{
+		"unknown key":  {file: "cache:\n  size: 1\n"},
+		"bad duration": {file: "containers:\n  idle_timeout: soon\n"},
+		"no file":      {args: []string{"-config", "/nonexistent/config.yaml"}},
+		"bad env":      {env: map[string]string{"CONTAINER_SCHEDULER_REPLICAS": "many"}},
+		"bad flag":     {args: []string{"-replicas", "many"}},
+		"zero timeout": {args: []string{"-init-timeout", "0s"}},
+		"bad policy":   {env: map[string]string{"CONTAINER_SCHEDULER_CACHE_POLICY": "random"}},
+		"bad level":    {args: []string{"-log-level", "loud"}},
+		"bad port":     {args: []string{"-port", "0"}},
+		"no admin":     {args: []string{"-admin-addr", ""}},
+		"bad runtime":  {args: []string{"-docker", "podman"}},
+		"bad max wait": {args: []string{"-max-containers-wait", "-1s"}},
+		"bad gc after": {args: []string{"-gc-after", "-1m"}},
+		"no cooldown":  {args: []string{"-breaker-failures", "5", "-breaker-cooldown", "0s"}},
+		"bad orphans":  {args: []string{"-orphans", "keep"}},
+		"no jobs":      {args: []string{"-max-jobs", "0"}},
+		"bad pinned":   {args: []string{"-pinned", "1,other/x"}},
+		"bad alpha":    {args: []string{"-forecast", "-forecast-alpha", "2"}},
+		"bad idle":     {args: []string{"-idle-timeouts", "1=soon"}},
+		"no weight":    {args: []string{"-adaptive-idle", "-idle-restart-weight", "0"}},
+		"no backoff":   {args: []string{"-calculation-retries", "2", "-retry-backoff", "0s"}},
+		"bad retries":  {args: []string{"-calculation-retries", "-1"}},
+	} {
+		args := tc.args
+		if tc.file != "" {
+			args = append(args, "-config", writeConfig(t, tc.file))
+		}
+
+		_, err := Load(args, env(tc.env))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestConfig_PinnedSeeds(t *testing.T) {
+	cfg, err := Load(
+		[]string{"-pinned", "1, other/2"},
+		env(map[string]string{"CONTAINER_SCHEDULER_PINNED": "3"}),
+	)
+	require.NoError(t, err)
+
+	got, err := cfg.PinnedSeeds()
+
+	require.NoError(t, err)
+	assert.Equal(t, []containersmap.Key{{Image: "qual-2021", Seed: 1}, {Image: "other", Seed: 2}}, got)
+
+	path := writeConfig(t, "containers_map:\n  pinned: [5, qual-2021/6]\n")
+	cfg, err = Load([]string{"-config", path}, env(nil))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"5", "qual-2021/6"}, cfg.ContainersMap.Pinned)
+}
+
+func TestConfig_SeedIdleTimeouts(t *testing.T) {
+	cfg, err := Load([]string{"-idle-timeouts", "1=1h, other/2=5m"}, env(nil))
+	require.NoError(t, err)
+
+	got, err := cfg.SeedIdleTimeouts()
+
+	require.NoError(t, err)
+	assert.Equal(t, map[containersmap.Key]time.Duration{
+		{Image: "qual-2021", Seed: 1}: time.Hour,
+		{Image: "other", Seed: 2}:     5 * time.Minute,
+	}, got)
+
+	for _, invalid := range []string{"1", "1=0s", "x=1m"} {
+		cfg.ContainersMap.IdleTimeouts = []string{invalid}
+		_, err = cfg.SeedIdleTimeouts()
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestConfig_Reloadable(t *testing.T) {
+	cfg := Default()
+	next := Default()
+	next.Server.Port = 8000
+	next.Cache.Policy = "lfu"
+	next.Cache.MaxEntries = 10
+	next.Containers.IdleTimeout = time.Minute
+	next.Docker.Runtime = "api"
+	next.Docker.Socket = "/run/docker.sock"
+	next.Docker.Orphans = "adopt"
+
+	got, ignored := cfg.Reloadable(next)
+
+	assert.Equal(t, []string{"server", "cache.policy", "docker.runtime", "docker.socket", "docker.orphans"}, ignored)
+	assert.Equal(t, cfg.Docker, got.Docker)
+	assert.Equal(t, cfg.Server, got.Server)
+	assert.Equal(t, cfg.Cache.Policy, got.Cache.Policy)
+	assert.Equal(t, 10, got.Cache.MaxEntries)
+	assert.Equal(t, time.Minute, got.Containers.IdleTimeout)
+}
+
+func TestConfig_NewSeedsOnly(t *testing.T) {
+	cfg := Default()
+	next := Default()
+	next.Deduplicator.Replicas = 3
+	next.Deduplicator.Scheduling = "fifo"
+	next.Containers.IdleTimeout = time.Minute
+
+	assert.Equal(t, []string{"deduplicator.replicas", "deduplicator.scheduling"}, cfg.NewSeedsOnly(next))
+	assert.Empty(t, cfg.NewSeedsOnly(cfg))
+}
+
+func TestConfig_String(t *testing.T) {
+	cfg := Default()
+
+	path := writeConfig(t, cfg.String())
+	got, err := Load([]string{"-config", path}, env(nil))
+
+	require.NoError(t, err)
+	assert.Equal(t, cfg, got)
+}
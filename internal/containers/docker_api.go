@@ -0,0 +1,378 @@
+package containers
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+)
+
+const (
+	dockerAPIVersion  = "v1.41"
+	dockerAPITimeout  = 5 * time.Minute
+	dockerStopTimeout = 10
+	dockerLogsTail    = 20
+)
+
+// dockerAPI is a controller for starting and stopping docker containers using
+// Docker Engine HTTP API over a unix socket.
+type dockerAPI struct {
+	l                   *zap.SugaredLogger
+	client              *http.Client
+	imageName, imageTag string
+	port                int
+	containerPort       string
+	name                string
+	envs                [][]string
+	labels              map[string]string
+}
+
+// DockerError is a non-successful response of Docker Engine API.
+type DockerError struct {
+	Op         string
+	StatusCode int
+	Message    string
+}
+
+func (e *DockerError) Error() string {
+	return fmt.Sprintf("docker %s: status %d: %s", e.Op, e.StatusCode, e.Message)
+}
+
+func newDockerAPI(
+	logger *zap.SugaredLogger,
+	socketPath string,
+	imageName, imageTag string,
+	port, containerPort int, name string,
+	envs [][]string, labels map[string]string,
+) *dockerAPI {
+	dialer := &net.Dialer{}
+	return &dockerAPI{
+		l: logger,
+		client: &http.Client{
+			Timeout: dockerAPITimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+		imageName:     imageName,
+		imageTag:      imageTag,
+		port:          port,
+		containerPort: fmt.Sprintf("%d/tcp", containerPort),
+		name:          name,
+		envs:          envs,
+		labels:        labels,
+	}
+}
+
+// Run creates and starts the docker container, pulling the image if it is absent.
+// A container with the same name left by a failed start or stop is replaced.
+func (d *dockerAPI) Run() error {
+	err := d.create()
+	if isConflict(err) {
+		d.l.Infof("docker container %q already exists, removing", d.name)
+		err = d.remove(d.name)
+		if err != nil {
+			return fmt.Errorf("cannot remove existing docker container: %w", err)
+		}
+
+		err = d.create()
+	}
+	if isNotFound(err) {
+		d.l.Infof("image %s:%s not found, pulling", d.imageName, d.imageTag)
+		err = d.pull()
+		if err != nil {
+			return fmt.Errorf("cannot pull image: %w", err)
+		}
+
+		err = d.create()
+	}
+	if err != nil {
+		return fmt.Errorf("cannot create docker container: %w", err)
+	}
+
+	err = d.do(http.MethodPost, "/containers/"+d.name+"/start", nil, nil, "start")
+	if err != nil {
+		return fmt.Errorf("cannot start docker container: %w", err)
+	}
+
+	running, err := d.inspect()
+	if err != nil {
+		return fmt.Errorf("cannot inspect docker container: %w", err)
+	}
+	if !running {
+		return fmt.Errorf("docker container %q exited: %s", d.name, d.logs())
+	}
+
+	d.l.Infof("ran docker container.")
+	return nil
+}
+
+// Stop stops the container and remove it. A container that does not exist is not an error.
+func (d *dockerAPI) Stop() error {
+	path := fmt.Sprintf("/containers/%s/stop?t=%d", d.name, dockerStopTimeout)
+	err := d.do(http.MethodPost, path, nil, nil, "stop")
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot stop docker container %q: %w", d.name, err)
+	}
+
+	err = d.remove(d.name)
+	if err != nil {
+		return fmt.Errorf("cannot rm docker container %q: %w", d.name, err)
+	}
+
+	return nil
+}
+
+// remove force removes the container by the name or id, a missing container is not an error.
+func (d *dockerAPI) remove(nameOrID string) error {
+	err := d.do(http.MethodDelete, "/containers/"+nameOrID+"?force=true", nil, nil, "remove")
+	if isNotFound(err) {
+		return nil
+	}
+
+	return err
+}
+
+// removeAll force removes all containers with names starting with the prefix.
+func (d *dockerAPI) removeAll(prefix string) error {
+	list, err := d.list(map[string][]string{"name": {"^/" + prefix}})
+	if err != nil {
+		return err
+	}
+
+	var errs error
+	for _, c := range list {
+		d.l.Infof("removing leftover docker container %v", c.Names)
+		errs = multierr.Append(errs, d.remove(c.ID))
+	}
+
+	return errs
+}
+
+// listOwned returns inspections of all containers with the owner label.
+func (d *dockerAPI) listOwned() ([]inspectResponse, error) {
+	list, err := d.list(map[string][]string{"label": {labelOwner + "=" + ownerValue}})
+	if err != nil {
+		return nil, err
+	}
+
+	inspections := make([]inspectResponse, 0, len(list))
+	for _, c := range list {
+		var body bytes.Buffer
+		err = d.do(http.MethodGet, "/containers/"+c.ID+"/json", nil, &body, "inspect")
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot inspect docker container %v: %w", c.Names, err)
+		}
+
+		var resp inspectResponse
+		err = json.Unmarshal(body.Bytes(), &resp)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse inspect response: %w", err)
+		}
+		inspections = append(inspections, resp)
+	}
+
+	return inspections, nil
+}
+
+type listedContainer struct {
+	ID    string   `json:"Id"`
+	Names []string `json:"Names"`
+}
+
+// list returns all containers that match the filters.
+func (d *dockerAPI) list(filters map[string][]string) ([]listedContainer, error) {
+	b, err := json.Marshal(filters)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal filters: %w", err)
+	}
+
+	var body bytes.Buffer
+	query := url.Values{"all": {"true"}, "filters": {string(b)}}
+	err = d.do(http.MethodGet, "/containers/json?"+query.Encode(), nil, &body, "list")
+	if err != nil {
+		return nil, fmt.Errorf("cannot list docker containers: %w", err)
+	}
+
+	var list []listedContainer
+	err = json.Unmarshal(body.Bytes(), &list)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse list response: %w", err)
+	}
+
+	return list, nil
+}
+
+func isNotFound(err error) bool {
+	var dErr *DockerError
+	return errors.As(err, &dErr) && dErr.StatusCode == http.StatusNotFound
+}
+
+func isConflict(err error) bool {
+	var dErr *DockerError
+	return errors.As(err, &dErr) && dErr.StatusCode == http.StatusConflict
+}
+
+type createRequest struct {
+	Image        string              `json:"Image"`
+	Env          []string            `json:"Env"`
+	Labels       map[string]string   `json:"Labels"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts"`
+	HostConfig   hostConfig          `json:"HostConfig"`
+}
+
+type hostConfig struct {
+	PortBindings map[string][]portBinding `json:"PortBindings"`
+}
+
+type portBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string `json:"HostPort"`
+}
+
+func (d *dockerAPI) create() error {
+	var env []string
+	for _, kv := range d.envs {
+		env = append(env, strings.Join(kv, "="))
+	}
+
+	req := createRequest{
+		Image:        d.imageName + ":" + d.imageTag,
+		Env:          env,
+		Labels:       d.labels,
+		ExposedPorts: map[string]struct{}{d.containerPort: {}},
+		HostConfig: hostConfig{
+			PortBindings: map[string][]portBinding{
+				d.containerPort: {{HostIP: "127.0.0.1", HostPort: strconv.Itoa(d.port)}},
+			},
+		},
+	}
+
+	return d.do(http.MethodPost, "/containers/create?name="+url.QueryEscape(d.name), req, nil, "create")
+}
+
+func (d *dockerAPI) pull() error {
+	query := url.Values{"fromImage": {d.imageName}, "tag": {d.imageTag}}
+	// the progress stream is read to the end, so the call returns after the pull.
+	return d.do(http.MethodPost, "/images/create?"+query.Encode(), nil, io.Discard, "pull")
+}
+
+func (d *dockerAPI) inspect() (bool, error) {
+	var resp inspectResponse
+
+	var body bytes.Buffer
+	err := d.do(http.MethodGet, "/containers/"+d.name+"/json", nil, &body, "inspect")
+	if err != nil {
+		return false, err
+	}
+
+	err = json.Unmarshal(body.Bytes(), &resp)
+	if err != nil {
+		return false, fmt.Errorf("cannot parse inspect response: %w", err)
+	}
+
+	return resp.State.Running, nil
+}
+
+// logs returns the last lines of the container output for diagnostics.
+func (d *dockerAPI) logs() string {
+	path := fmt.Sprintf("/containers/%s/logs?stdout=1&stderr=1&tail=%d", d.name, dockerLogsTail)
+
+	var body bytes.Buffer
+	err := d.do(http.MethodGet, path, nil, &body, "logs")
+	if err != nil {
+		return fmt.Sprintf("cannot get logs: %s", err.Error())
+	}
+
+	return demultiplexLogs(body.Bytes())
+}
+
+// do sends a request to Docker Engine API. If out is not nil, the response body is copied to it.
+func (d *dockerAPI) do(method, path string, in interface{}, out io.Writer, op string) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("cannot marshal %s request: %w", op, err)
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, "http://docker/"+dockerAPIVersion+path, body)
+	if err != nil {
+		return fmt.Errorf("cannot create %s request: %w", op, err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot do %s request: %w", op, err)
+	}
+	defer resp.Body.Close()
+
+	// 304 means that a container is already started or stopped.
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotModified {
+		return newDockerError(op, resp)
+	}
+
+	if out != nil {
+		_, err = io.Copy(out, resp.Body)
+		if err != nil {
+			return fmt.Errorf("cannot read %s response: %w", op, err)
+		}
+	}
+
+	return nil
+}
+
+func newDockerError(op string, resp *http.Response) *DockerError {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	var msg struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(b, &msg) != nil || msg.Message == "" {
+		msg.Message = strings.TrimSpace(string(b))
+	}
+
+	return &DockerError{Op: op, StatusCode: resp.StatusCode, Message: msg.Message}
+}
+
+// demultiplexLogs strips headers of stdout and stderr frames of a non-TTY container.
+func demultiplexLogs(b []byte) string {
+	var sb strings.Builder
+	for len(b) >= 8 {
+		size := int(binary.BigEndian.Uint32(b[4:8]))
+		b = b[8:]
+		if size > len(b) {
+			size = len(b)
+		}
+
+		sb.Write(b[:size])
+		b = b[size:]
+	}
+
+	return strings.TrimSpace(sb.String())
+}
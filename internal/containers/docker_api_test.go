@@ -0,0 +1,228 @@
+package containers
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// fakeDocker is a minimal Docker Engine API served over a unix socket.
+type fakeDocker struct {
+	mu          sync.Mutex
+	calls       []string
+	imagePulled bool
+	exists      bool
+	running     bool
+	created     createRequest
+}
+
+func (f *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, r.Method+" "+r.URL.Path)
+
+	switch r.Method + " " + r.URL.Path {
+	case "POST /v1.41/containers/create":
+		if !f.imagePulled {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"No such image"}`))
+			return
+		}
+		if f.exists {
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(`{"message":"name is already in use"}`))
+			return
+		}
+		f.exists = true
+		_ = json.NewDecoder(r.Body).Decode(&f.created)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"Id":"abc"}`))
+	case "POST /v1.41/images/create":
+		f.imagePulled = true
+		_, _ = w.Write([]byte(`{"status":"Downloaded"}`))
+	case "POST /v1.41/containers/qual_1_seed_1/start":
+		f.running = true
+		w.WriteHeader(http.StatusNoContent)
+	case "GET /v1.41/containers/qual_1_seed_1/json":
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"State": map[string]bool{"Running": f.running},
+		})
+	case "GET /v1.41/containers/qual_1_seed_1/logs":
+		frame := []byte("boom\n")
+		header := make([]byte, 8)
+		header[0] = 2
+		binary.BigEndian.PutUint32(header[4:], uint32(len(frame)))
+		_, _ = w.Write(append(header, frame...))
+	case "POST /v1.41/containers/qual_1_seed_1/stop":
+		if !f.running {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		f.running = false
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE /v1.41/containers/qual_1_seed_1":
+		f.exists, f.running = false, false
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"message":"unexpected call"}`))
+	}
+}
+
+func newTestDockerAPI(t *testing.T, handler http.Handler) *dockerAPI {
+	t.Helper()
+
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	l, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+
+	s := &http.Server{Handler: handler}
+	go func() { _ = s.Serve(l) }()
+	t.Cleanup(func() { _ = s.Close() })
+
+	return newDockerAPI(
+		zap.NewNop().Sugar(), socket,
+		"quay.io/image", "latest",
+		9090, 8080, "qual_1_seed_1",
+		[][]string{{"SEED", "1 2"}}, map[string]string{labelOwner: ownerValue},
+	)
+}
+
+func TestDockerAPI_RunStop(t *testing.T) {
+	f := &fakeDocker{}
+	d := newTestDockerAPI(t, f)
+
+	require.NoError(t, d.Run())
+	require.NoError(t, d.Stop())
+
+	assert.Equal(t, []string{
+		"POST /v1.41/containers/create",
+		"POST /v1.41/images/create",
+		"POST /v1.41/containers/create",
+		"POST /v1.41/containers/qual_1_seed_1/start",
+		"GET /v1.41/containers/qual_1_seed_1/json",
+		"POST /v1.41/containers/qual_1_seed_1/stop",
+		"DELETE /v1.41/containers/qual_1_seed_1",
+	}, f.calls)
+	assert.Equal(t, "quay.io/image:latest", f.created.Image)
+	assert.Equal(t, []string{"SEED=1 2"}, f.created.Env)
+	assert.Equal(t, map[string]string{labelOwner: ownerValue}, f.created.Labels)
+	assert.Equal(t, "9090", f.created.HostConfig.PortBindings["8080/tcp"][0].HostPort)
+}
+
+func TestDockerAPI_Run_Existing(t *testing.T) {
+	f := &fakeDocker{imagePulled: true, exists: true, running: true}
+	d := newTestDockerAPI(t, f)
+
+	require.NoError(t, d.Run())
+
+	assert.Equal(t, []string{
+		"POST /v1.41/containers/create",
+		"DELETE /v1.41/containers/qual_1_seed_1",
+		"POST /v1.41/containers/create",
+		"POST /v1.41/containers/qual_1_seed_1/start",
+		"GET /v1.41/containers/qual_1_seed_1/json",
+	}, f.calls)
+	assert.True(t, f.running)
+}
+
+func TestDockerAPI_Run_Exited(t *testing.T) {
+	f := &fakeDocker{imagePulled: true}
+	d := newTestDockerAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.ServeHTTP(w, r)
+		f.mu.Lock()
+		f.running = false
+		f.mu.Unlock()
+	}))
+
+	err := d.Run()
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exited: boom")
+}
+
+func TestDockerAPI_Stop_Error(t *testing.T) {
+	d := newTestDockerAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"message":"cannot kill container: qual_1_seed_1"}`))
+	}))
+
+	err := d.Stop()
+
+	var dErr *DockerError
+	require.ErrorAs(t, err, &dErr)
+	assert.Equal(t, http.StatusInternalServerError, dErr.StatusCode)
+	assert.Equal(t, "stop", dErr.Op)
+	assert.Equal(t, "cannot kill container: qual_1_seed_1", dErr.Message)
+}
+
+func TestDockerAPI_Stop_NotFound(t *testing.T) {
+	d := newTestDockerAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"message":"No such container: qual_1_seed_1"}`))
+	}))
+
+	require.NoError(t, d.Stop())
+}
+
+func TestDockerAPI_removeAll(t *testing.T) {
+	var removed []string
+	d := newTestDockerAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			assert.Equal(t, "/v1.41/containers/json", r.URL.Path)
+			assert.Equal(t, `{"name":["^/qual_"]}`, r.URL.Query().Get("filters"))
+			_, _ = w.Write([]byte(`[{"Id":"a","Names":["/qual_1_seed_1"]},{"Id":"b","Names":["/qual_2_seed_2"]}]`))
+		case http.MethodDelete:
+			removed = append(removed, r.URL.Path)
+			if r.URL.Path == "/v1.41/containers/b" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+
+	require.NoError(t, d.removeAll(namePrefix))
+	assert.Equal(t, []string{"/v1.41/containers/a", "/v1.41/containers/b"}, removed)
+}
+
+func TestDockerAPI_listOwned(t *testing.T) {
+	d := newTestDockerAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1.41/containers/json":
+			assert.Equal(t, `{"label":["container_scheduler.owner=container_scheduler"]}`, r.URL.Query().Get("filters"))
+			_, _ = w.Write([]byte(`[{"Id":"a","Names":["/qual_1_seed_1"]},{"Id":"b","Names":["/qual_2_seed_2"]}]`))
+		case "/v1.41/containers/a/json":
+			_, _ = w.Write([]byte(`{
+				"Name": "/qual_1_seed_1",
+				"State": {"Running": true},
+				"Config": {"Labels": {"container_scheduler.image": "qual-2021", "container_scheduler.seed": "1"}},
+				"HostConfig": {"PortBindings": {"8080/tcp": [{"HostIp": "", "HostPort": "9090"}]}}
+			}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	got, err := d.listOwned()
+
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, Orphan{
+		Name:    "qual_1_seed_1",
+		Image:   "qual-2021",
+		Seed:    1,
+		Running: true,
+		Ports:   map[int]int{8080: 9090},
+	}, got[0].orphan())
+}
@@ -1,11 +1,16 @@
 package containers
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"sort"
 	"strings"
 
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 )
 
@@ -14,30 +19,44 @@ import (
 type docker struct {
 	l                   *zap.SugaredLogger
 	imageName, imageTag string
-	port                int
+	port, containerPort int
 	name                string
 	envs                [][]string
+	labels              map[string]string
 }
 
 func newDocker(
 	logger *zap.SugaredLogger,
 	imageName, imageTag string,
-	port int, name string,
-	envs [][]string,
+	port, containerPort int, name string,
+	envs [][]string, labels map[string]string,
 ) *docker {
 	return &docker{
-		l:         logger,
-		imageName: imageName,
-		imageTag:  imageTag,
-		port:      port,
-		name:      name,
-		envs:      envs,
+		l:             logger,
+		imageName:     imageName,
+		imageTag:      imageTag,
+		port:          port,
+		containerPort: containerPort,
+		name:          name,
+		envs:          envs,
+		labels:        labels,
 	}
 }
 
 // Run creates and starts the docker container.
+// A container with the same name left by a failed start or stop is replaced.
 func (d *docker) Run() error {
-	err := runCmd(d.getRunString())
+	args := d.getRunArgs()
+	err := runCmd(args...)
+	if isNameInUse(err) {
+		d.l.Infof("docker container %q already exists, removing", d.name)
+		err = d.remove(d.name)
+		if err != nil {
+			return fmt.Errorf("cannot remove existing docker container: %w", err)
+		}
+
+		err = runCmd(args...)
+	}
 	if err != nil {
 		return fmt.Errorf("cannot run docker container: %w", err)
 	}
@@ -46,26 +65,40 @@ func (d *docker) Run() error {
 	return nil
 }
 
-func (d *docker) getRunString() string {
-	var pairs []string
+// getRunArgs returns arguments of docker run, every value is one argument,
+// so env values may contain spaces.
+func (d *docker) getRunArgs() []string {
+	args := []string{"run", "--detach", "--publish", fmt.Sprintf("%d:%d", d.port, d.containerPort)}
 	for _, kv := range d.envs {
-		pairs = append(pairs, strings.Join(kv, "="))
+		args = append(args, "--env", strings.Join(kv, "="))
+	}
+
+	keys := make([]string, 0, len(d.labels))
+	for k := range d.labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, "--label", k+"="+d.labels[k])
 	}
-	environs := strings.Join(pairs, " ")
 
-	return fmt.Sprintf(
-		"run --detach --publish %d:8080 --env %s --name %s %s:%s",
-		d.port, environs, d.name, d.imageName, d.imageTag)
+	return append(args, "--name", d.name, d.imageName+":"+d.imageTag)
 }
 
-// Stop stops the container and remove it.
+// Stop stops the container and remove it. A container that does not exist is not an error.
 func (d *docker) Stop() error {
-	err := runCmd(fmt.Sprintf("stop %s", d.name))
+	err := runCmd("stop", d.name)
+	if isNoSuchContainer(err) {
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("cannot stop docker container %q: %w", d.name, err)
 	}
 
-	err = runCmd(fmt.Sprintf("rm %s", d.name))
+	err = runCmd("rm", d.name)
+	if isNoSuchContainer(err) {
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("cannot rm docker container %q: %w", d.name, err)
 	}
@@ -73,11 +106,85 @@ func (d *docker) Stop() error {
 	return nil
 }
 
-func runCmd(cmdStr string) error {
-	cmd := exec.Command("docker", strings.Split(cmdStr, " ")...)
-	cmd.Stdout = os.Stdout
+// removeAll force removes all containers with names starting with the prefix.
+func (d *docker) removeAll(prefix string) error {
+	out, err := outputCmd("ps", "--all", "--quiet", "--filter", "name=^/"+prefix)
+	if err != nil {
+		return fmt.Errorf("cannot list docker containers: %w", err)
+	}
+
+	var errs error
+	for _, id := range strings.Fields(string(out)) {
+		d.l.Infof("removing leftover docker container %s", id)
+		errs = multierr.Append(errs, d.remove(id))
+	}
+
+	return errs
+}
+
+// remove force removes the container by the name or id.
+func (d *docker) remove(nameOrID string) error {
+	return runCmd("rm", "--force", nameOrID)
+}
+
+// listOwned returns inspections of all containers with the owner label.
+func (d *docker) listOwned() ([]inspectResponse, error) {
+	out, err := outputCmd("ps", "--all", "--quiet", "--filter", "label="+labelOwner+"="+ownerValue)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list docker containers: %w", err)
+	}
+
+	ids := strings.Fields(string(out))
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	out, err = outputCmd(append([]string{"inspect"}, ids...)...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot inspect docker containers: %w", err)
+	}
+
+	var inspections []inspectResponse
+	err = json.Unmarshal(out, &inspections)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse inspect output: %w", err)
+	}
+
+	return inspections, nil
+}
+
+// outputCmd runs the docker command and returns its output.
+func outputCmd(args ...string) ([]byte, error) {
+	var out bytes.Buffer
+	cmd := exec.Command("docker", args...)
+	cmd.Stdout = &out
 	cmd.Stderr = os.Stderr
 
+	err := cmd.Run()
+	if err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// isNoSuchContainer reports whether the docker command failed because the container does not exist.
+func isNoSuchContainer(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "No such container")
+}
+
+// isNameInUse reports whether docker run failed because a container with the name exists.
+func isNameInUse(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "is already in use")
+}
+
+// runCmd runs the docker command with the arguments, its output goes to the output of the process.
+func runCmd(args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("docker", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+
 	err := cmd.Start()
 	if err != nil {
 		return fmt.Errorf("cannot start cmd exec: %w", err)
@@ -85,7 +192,7 @@ func runCmd(cmdStr string) error {
 
 	err = cmd.Wait()
 	if err != nil {
-		return fmt.Errorf("cannot end cmd exec: %w", err)
+		return fmt.Errorf("cannot end cmd exec: %w: %s", err, strings.TrimSpace(stderr.String()))
 	}
 
 	return nil
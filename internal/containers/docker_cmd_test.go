@@ -0,0 +1,73 @@
+package containers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// fakeDockerCLI puts a docker script that runs the body into PATH and returns a path
+// of the file where the script appends its arguments, one per line.
+func fakeDockerCLI(t *testing.T, body string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	argsPath := filepath.Join(dir, "args")
+	script := "#!/bin/sh\nfor arg in \"$@\"; do echo \"$arg\" >> " + argsPath + "; done\n" + body + "\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "docker"), []byte(script), 0o755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	return argsPath
+}
+
+func TestDocker_Stop_NoSuchContainer(t *testing.T) {
+	argsPath := fakeDockerCLI(t, `echo "Error response from daemon: No such container: $2" >&2; exit 1`)
+	d := newDocker(zap.NewNop().Sugar(), "qual", "latest", 1, 5000, "qual_1_seed_1", nil, nil)
+
+	require.NoError(t, d.Stop())
+
+	args, err := os.ReadFile(argsPath)
+	require.NoError(t, err)
+	assert.Equal(t, "stop\nqual_1_seed_1", strings.TrimSpace(string(args)), "rm is not called")
+}
+
+func TestDocker_Stop_Error(t *testing.T) {
+	fakeDockerCLI(t, `echo "Cannot connect to the Docker daemon" >&2; exit 1`)
+	d := newDocker(zap.NewNop().Sugar(), "qual", "latest", 1, 5000, "qual_1_seed_1", nil, nil)
+
+	err := d.Stop()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Cannot connect to the Docker daemon")
+}
+
+func TestDocker_Run(t *testing.T) {
+	existsPath := filepath.Join(t.TempDir(), "exists")
+	require.NoError(t, os.WriteFile(existsPath, nil, 0o644))
+	argsPath := fakeDockerCLI(t, `
+case "$1" in
+run)
+  if [ -e `+existsPath+` ]; then
+    echo 'docker: Error response from daemon: Conflict. The container name "/qual_1_seed_1" is already in use.' >&2
+    exit 125
+  fi
+  touch `+existsPath+`;;
+rm) rm -f `+existsPath+`;;
+esac`)
+	d := newDocker(
+		zap.NewNop().Sugar(), "qual", "latest", 1, 5000, "qual_1_seed_1",
+		[][]string{{"MODE", "fast and exact"}}, map[string]string{"owner": "scheduler"},
+	)
+
+	// the container left by a failed stop is replaced
+	require.NoError(t, d.Run())
+
+	args, err := os.ReadFile(argsPath)
+	require.NoError(t, err)
+	run := "run\n--detach\n--publish\n1:5000\n--env\nMODE=fast and exact\n--label\nowner=scheduler\n--name\nqual_1_seed_1\nqual:latest\n"
+	assert.Equal(t, run+"rm\n--force\nqual_1_seed_1\n"+run, string(args))
+}
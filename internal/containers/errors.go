@@ -0,0 +1,66 @@
+package containers
+
+import "errors"
+
+var (
+	// ErrStart is returned when docker cannot run a container.
+	ErrStart = errors.New("cannot start container")
+	// ErrInitTimeout is returned when a container is not healthy after the initialization timeout.
+	ErrInitTimeout = errors.New("container was initializing for too long")
+	// ErrClosed is returned when a Qual is closed during a calculation.
+	ErrClosed = errors.New("qual is closed")
+	// ErrUnreachable is returned when a container does not answer a calculation request.
+	ErrUnreachable = errors.New("container is unreachable")
+	// ErrCalculationTimeout is returned when a container does not answer in the calculation timeout.
+	ErrCalculationTimeout = errors.New("calculation timed out")
+	// ErrBadUpstreamResponse is returned when a container answers with a body that is not a result.
+	ErrBadUpstreamResponse = errors.New("bad upstream response")
+	// ErrBadInput is returned when a container rejects an input with status 400 or 422,
+	// the same input fails again.
+	ErrBadInput = errors.New("container rejected the input")
+	// ErrUpstreamFailure is returned when a container answers with a 5xx or another unexpected
+	// status including 408 and 429.
+	ErrUpstreamFailure = errors.New("container failed to calculate")
+)
+
+// Retryable reports whether a calculation that failed with err may succeed if it is repeated.
+func Retryable(err error) bool {
+	return errors.Is(err, ErrUpstreamFailure) || errors.Is(err, ErrUnreachable)
+}
+
+// Failed reports whether err is a failure of a container to start or to calculate,
+// not of the request itself.
+func Failed(err error) bool {
+	for _, kind := range []error{
+		ErrStart, ErrInitTimeout, ErrUnreachable, ErrCalculationTimeout, ErrBadUpstreamResponse, ErrUpstreamFailure,
+	} {
+		if errors.Is(err, kind) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// kindError marks an error with one of the sentinels above without changing its message,
+// errors.Is matches both the sentinel and the wrapped error.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func withKind(kind, err error) error {
+	return kindError{kind: kind, err: err}
+}
+
+func (e kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e kindError) Unwrap() error {
+	return e.err
+}
+
+func (e kindError) Is(target error) bool {
+	return target == e.kind
+}
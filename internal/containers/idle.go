@@ -0,0 +1,94 @@
+package containers
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// maxGaps is a count of the latest idle gaps that an idle timeout is chosen by.
+	maxGaps = 32
+	// minGaps is a count of gaps after which they are used instead of the break-even timeout.
+	minGaps = 5
+	// minAdaptiveIdle is the shortest adaptive idle timeout.
+	minAdaptiveIdle = 10 * time.Second
+)
+
+// idlePolicy chooses an idle timeout of a container from its initialization duration
+// and gaps between calculations, like in the ski rental problem: the container is kept
+// idle while it is cheaper than a restart.
+type idlePolicy struct {
+	mu           sync.Mutex
+	gaps         []time.Duration
+	next         int
+	initDuration time.Duration
+}
+
+// observeGap records a time without calculations between two of them.
+func (p *idlePolicy) observeGap(gap time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.gaps) < maxGaps {
+		p.gaps = append(p.gaps, gap)
+		return
+	}
+
+	p.gaps[p.next] = gap
+	p.next = (p.next + 1) % maxGaps
+}
+
+// observeInit records the duration of the latest initialization.
+func (p *idlePolicy) observeInit(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.initDuration = d
+}
+
+// timeout returns the idle timeout in [minAdaptiveIdle, max] for a restart that costs
+// weight initializations. Until an initialization is measured it returns the fallback.
+func (p *idlePolicy) timeout(fallback, max time.Duration, weight float64) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.initDuration == 0 {
+		return fallback
+	}
+
+	restart := time.Duration(weight * float64(p.initDuration))
+	idle := restart // the break-even timeout is 2-competitive without knowing the gaps
+	if len(p.gaps) >= minGaps {
+		idle = skiRental(p.gaps, restart)
+	}
+
+	if idle < minAdaptiveIdle {
+		idle = minAdaptiveIdle
+	}
+	if idle > max {
+		idle = max
+	}
+
+	return idle
+}
+
+// skiRental returns the idle timeout with the least total cost of the gaps: a gap that is
+// not longer than the timeout costs its length, a longer one costs the timeout and a restart.
+// Only 0 and the gaps themselves can be optimal.
+func skiRental(gaps []time.Duration, restart time.Duration) time.Duration {
+	sorted := append([]time.Duration(nil), gaps...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	best, bestCost := time.Duration(0), time.Duration(len(sorted))*restart
+	var covered time.Duration
+	for i, gap := range sorted {
+		covered += gap
+		cost := covered + time.Duration(len(sorted)-i-1)*(gap+restart)
+		if cost < bestCost {
+			best, bestCost = gap, cost
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,49 @@
+package containers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSkiRental(t *testing.T) {
+	for name, tc := range map[string]struct {
+		gaps    []time.Duration
+		restart time.Duration
+		want    time.Duration
+	}{
+		"short gaps are covered": {
+			gaps:    []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute, 4 * time.Minute, 5 * time.Minute},
+			restart: 10 * time.Minute,
+			want:    5 * time.Minute,
+		},
+		"a long gap is not waited for": {
+			gaps:    []time.Duration{time.Minute, time.Minute, time.Hour, time.Minute, time.Minute},
+			restart: 4 * time.Minute,
+			want:    time.Minute,
+		},
+		"cheap restarts": {
+			gaps:    []time.Duration{time.Minute, time.Minute, time.Minute},
+			restart: time.Second,
+			want:    0,
+		},
+	} {
+		assert.Equal(t, tc.want, skiRental(tc.gaps, tc.restart), name)
+	}
+}
+
+func TestIdlePolicy_timeout(t *testing.T) {
+	p := &idlePolicy{}
+	assert.Equal(t, 2*time.Minute, p.timeout(2*time.Minute, time.Hour, 2), "no initialization is measured")
+
+	p.observeInit(30 * time.Second)
+	assert.Equal(t, time.Minute, p.timeout(2*time.Minute, time.Hour, 2), "the break-even timeout without gaps")
+	assert.Equal(t, 45*time.Second, p.timeout(2*time.Minute, 45*time.Second, 2), "the max idle timeout")
+
+	for i := 0; i < maxGaps+minGaps; i++ {
+		p.observeGap(time.Second)
+	}
+	assert.Len(t, p.gaps, maxGaps)
+	assert.Equal(t, minAdaptiveIdle, p.timeout(2*time.Minute, time.Hour, 2), "the min idle timeout")
+}
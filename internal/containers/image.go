@@ -0,0 +1,238 @@
+package containers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// DefaultImage is a name of the image that serves requests without an image in the path.
+	DefaultImage = "qual-2021"
+
+	seedPlaceholder  = "{seed}"
+	inputPlaceholder = "{input}"
+)
+
+// ErrUnknownImage is returned for an image that is not in the registry.
+var ErrUnknownImage = errors.New("unknown image")
+
+// NamePattern is a regular expression of image names, routes match names with it too.
+const NamePattern = `[a-z][a-z0-9_.-]*`
+
+var imageNameRe = regexp.MustCompile("^" + NamePattern + "$")
+
+// MaxNameLen is a maximum length of an image name, the cache log stores it with one length byte.
+const MaxNameLen = 255
+
+// ImageSpec describes a calculation image: how to run it and how to talk to it.
+type ImageSpec struct {
+	// Name is a key of the image in routes and in the cache.
+	Name  string `yaml:"name"`
+	Image string `yaml:"image"`
+	Tag   string `yaml:"tag"`
+	// Env maps environment variables to values, {seed} in a value is replaced with the seed.
+	Env map[string]string `yaml:"env"`
+	// Port is a port of the calculation server inside the container.
+	Port       int    `yaml:"port"`
+	HealthPath string `yaml:"health_path"`
+	// CalculatePath is a path of a calculation, {input} is replaced with the input.
+	CalculatePath string       `yaml:"calculate_path"`
+	Response      ResponseSpec `yaml:"response"`
+}
+
+// ResponseSpec describes a response body of a calculation.
+type ResponseSpec struct {
+	// Format is text for a plain integer or json for a JSON object.
+	Format string `yaml:"format"`
+	// Field is a field of the JSON object that holds the result.
+	Field string `yaml:"field"`
+}
+
+// Qual2021 is a spec of quay.io/milaboratory/qual-2021-devops-server.
+var Qual2021 = ImageSpec{
+	Name:          DefaultImage,
+	Image:         "quay.io/milaboratory/qual-2021-devops-server",
+	Tag:           "latest",
+	Env:           map[string]string{"SEED": seedPlaceholder},
+	Port:          8080,
+	HealthPath:    "/health",
+	CalculatePath: "/calculate/" + inputPlaceholder,
+	Response:      ResponseSpec{Format: "text"},
+}
+
+// Images is a registry of image specs by their names.
+type Images map[string]ImageSpec
+
+// DefaultImages returns a registry with Qual2021 only.
+func DefaultImages() Images {
+	return Images{DefaultImage: Qual2021}
+}
+
+// LoadImages reads a YAML or JSON file with a list of image specs:
+//
+//	images:
+//	  - name: qual-2021
+//	    image: quay.io/milaboratory/qual-2021-devops-server
+//	    ...
+func LoadImages(path string) (Images, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read images file: %w", err)
+	}
+
+	var file struct {
+		Images []ImageSpec `yaml:"images"`
+	}
+	err = yaml.Unmarshal(b, &file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse images file: %w", err)
+	}
+
+	images := make(Images, len(file.Images))
+	for _, spec := range file.Images {
+		err = spec.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid image %q: %w", spec.Name, err)
+		}
+
+		if _, ok := images[spec.Name]; ok {
+			return nil, fmt.Errorf("duplicated image %q", spec.Name)
+		}
+		images[spec.Name] = spec
+	}
+
+	return images, nil
+}
+
+// Get returns a spec by the name or ErrUnknownImage.
+func (images Images) Get(name string) (ImageSpec, error) {
+	spec, ok := images[name]
+	if !ok {
+		return ImageSpec{}, fmt.Errorf("%w: %q", ErrUnknownImage, name)
+	}
+
+	return spec, nil
+}
+
+// Validate checks that the spec is complete.
+func (spec ImageSpec) Validate() error {
+	switch {
+	case !imageNameRe.MatchString(spec.Name):
+		return fmt.Errorf("name %q should match %s", spec.Name, imageNameRe)
+	case len(spec.Name) > MaxNameLen:
+		return fmt.Errorf("name %q is longer than %d bytes", spec.Name, MaxNameLen)
+	case spec.Image == "" || spec.Tag == "":
+		return fmt.Errorf("empty image or tag")
+	case spec.Port <= 0 || spec.Port > 65535:
+		return fmt.Errorf("invalid port %d", spec.Port)
+	case !strings.HasPrefix(spec.HealthPath, "/"):
+		return fmt.Errorf("health path %q should start with /", spec.HealthPath)
+	case !strings.HasPrefix(spec.CalculatePath, "/") || !strings.Contains(spec.CalculatePath, inputPlaceholder):
+		return fmt.Errorf("calculate path %q should start with / and contain %s", spec.CalculatePath, inputPlaceholder)
+	}
+
+	switch spec.Response.Format {
+	case "text":
+	case "json":
+		if spec.Response.Field == "" {
+			return fmt.Errorf("json response needs a field")
+		}
+	default:
+		return fmt.Errorf("unknown response format %q", spec.Response.Format)
+	}
+
+	return nil
+}
+
+// envs returns environment variables of a container for the seed.
+func (spec ImageSpec) envs(seed int) [][]string {
+	keys := make([]string, 0, len(spec.Env))
+	for k := range spec.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	envs := make([][]string, 0, len(keys))
+	for _, k := range keys {
+		envs = append(envs, []string{k, strings.ReplaceAll(spec.Env[k], seedPlaceholder, strconv.Itoa(seed))})
+	}
+
+	return envs
+}
+
+func (spec ImageSpec) calculatePath(input int) string {
+	return strings.ReplaceAll(spec.CalculatePath, inputPlaceholder, strconv.Itoa(input))
+}
+
+// parseResult extracts the result from a calculation response body. Surrounding whitespace
+// is ignored, a text result may be a JSON string and a JSON field may be an integral float.
+func (spec ImageSpec) parseResult(body []byte) (int, error) {
+	body = bytes.TrimSpace(body)
+	if spec.Response.Format == "json" {
+		var obj map[string]json.RawMessage
+		err := json.Unmarshal(body, &obj)
+		if err != nil {
+			return 0, fmt.Errorf("cannot parse json body %q: %w", string(body), err)
+		}
+
+		raw, ok := obj[spec.Response.Field]
+		if !ok {
+			return 0, fmt.Errorf("no field %q in body %q", spec.Response.Field, string(body))
+		}
+
+		result, err := parseNumber(raw)
+		if err != nil {
+			return 0, fmt.Errorf("cannot parse field %q of body %q: %w", spec.Response.Field, string(body), err)
+		}
+
+		return result, nil
+	}
+
+	text := string(body)
+	var unquoted string
+	if len(body) > 0 && body[0] == '"' && json.Unmarshal(body, &unquoted) == nil {
+		text = strings.TrimSpace(unquoted)
+	}
+
+	result, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse body %q: %w", string(body), err)
+	}
+
+	return result, nil
+}
+
+// parseNumber parses a JSON number without a fractional part, e.g. 456 or 4.56e2.
+func parseNumber(raw json.RawMessage) (int, error) {
+	if len(raw) == 0 || raw[0] == '"' {
+		return 0, fmt.Errorf("%s is not a number", string(raw))
+	}
+
+	var n json.Number
+	err := json.Unmarshal(raw, &n)
+	if err != nil {
+		return 0, err
+	}
+
+	i, err := n.Int64()
+	if err == nil {
+		return int(i), nil
+	}
+
+	f, err := n.Float64()
+	if err != nil || f != math.Trunc(f) || math.Abs(f) > 1<<53 {
+		return 0, fmt.Errorf("%s is not an integer", n)
+	}
+
+	return int(f), nil
+}
@@ -0,0 +1,86 @@
+package containers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadImages(t *testing.T) {
+	images, err := LoadImages(filepath.Join("..", "..", "images.example.yaml"))
+	require.NoError(t, err)
+
+	assert.Equal(t, Qual2021, images[DefaultImage])
+
+	spec, err := images.Get("example-json")
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"MODE", "fast"}, {"RANDOM_SEED", "42"}}, spec.envs(42))
+	assert.Equal(t, "/v1/calculate?input=7", spec.calculatePath(7))
+
+	_, err = images.Get("unknown")
+	assert.ErrorIs(t, err, ErrUnknownImage)
+}
+
+func TestLoadImages_Invalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"duplicate": `
+images:
+  - {name: a, image: i, tag: t, port: 1, health_path: /h, calculate_path: /c/{input}, response: {format: text}}
+  - {name: a, image: i, tag: t, port: 1, health_path: /h, calculate_path: /c/{input}, response: {format: text}}
+`,
+		"no input":    `images: [{name: a, image: i, tag: t, port: 1, health_path: /h, calculate_path: /c, response: {format: text}}]`,
+		"bad name":    `images: [{name: A, image: i, tag: t, port: 1, health_path: /h, calculate_path: /c/{input}, response: {format: text}}]`,
+		"long name":   `images: [{name: ` + strings.Repeat("a", MaxNameLen+1) + `, image: i, tag: t, port: 1, health_path: /h, calculate_path: /c/{input}, response: {format: text}}]`,
+		"no field":    `images: [{name: a, image: i, tag: t, port: 1, health_path: /h, calculate_path: /c/{input}, response: {format: json}}]`,
+		"bad format":  `images: [{name: a, image: i, tag: t, port: 1, health_path: /h, calculate_path: /c/{input}, response: {format: xml}}]`,
+		"not yaml":    `images: [`,
+		"no port":     `images: [{name: a, image: i, tag: t, health_path: /h, calculate_path: /c/{input}, response: {format: text}}]`,
+		"no tag":      `images: [{name: a, image: i, port: 1, health_path: /h, calculate_path: /c/{input}, response: {format: text}}]`,
+		"bad healthz": `images: [{name: a, image: i, tag: t, port: 1, health_path: h, calculate_path: /c/{input}, response: {format: text}}]`,
+	} {
+		path := filepath.Join(t.TempDir(), "images.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+
+		_, err := LoadImages(path)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestImageSpec_parseResult(t *testing.T) {
+	res, err := Qual2021.parseResult([]byte("123"))
+	require.NoError(t, err)
+	assert.Equal(t, 123, res)
+
+	_, err = Qual2021.parseResult([]byte("abc"))
+	assert.Error(t, err)
+
+	spec := ImageSpec{Response: ResponseSpec{Format: "json", Field: "result"}}
+	res, err = spec.parseResult([]byte(`{"result": 456, "took": "1s"}`))
+	require.NoError(t, err)
+	assert.Equal(t, 456, res)
+
+	_, err = spec.parseResult([]byte(`{"value": 456}`))
+	assert.Error(t, err)
+
+	_, err = spec.parseResult([]byte(`{"result": "456"}`))
+	assert.Error(t, err)
+
+	res, err = Qual2021.parseResult([]byte(" 123\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 123, res)
+
+	res, err = Qual2021.parseResult([]byte(`"123"`))
+	require.NoError(t, err)
+	assert.Equal(t, 123, res)
+
+	res, err = spec.parseResult([]byte(`{"result": 4.56e2}` + "\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 456, res)
+
+	_, err = spec.parseResult([]byte(`{"result": 4.5}`))
+	assert.Error(t, err)
+}
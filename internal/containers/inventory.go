@@ -0,0 +1,165 @@
+package containers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	labelOwner = "container_scheduler.owner"
+	labelImage = "container_scheduler.image"
+	labelSeed  = "container_scheduler.seed"
+	ownerValue = "container_scheduler"
+)
+
+// ownerLabels marks a container of the scheduler, so it can be found after a restart.
+func ownerLabels(spec ImageSpec, seed int) map[string]string {
+	return map[string]string{
+		labelOwner: ownerValue,
+		labelImage: spec.Name,
+		labelSeed:  strconv.Itoa(seed),
+	}
+}
+
+// Orphan is a container of the scheduler left by a previous run.
+type Orphan struct {
+	Name string
+	// Image is a name of the image spec, it is empty if labels are missing or malformed.
+	Image   string
+	Seed    int
+	Running bool
+	// Ports maps container ports to published host ports.
+	Ports map[int]int
+}
+
+type inspectResponse struct {
+	Name  string `json:"Name"`
+	State struct {
+		Running bool `json:"Running"`
+	} `json:"State"`
+	Config struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+	HostConfig struct {
+		PortBindings map[string][]struct {
+			HostPort string `json:"HostPort"`
+		} `json:"PortBindings"`
+	} `json:"HostConfig"`
+}
+
+func (r inspectResponse) orphan() Orphan {
+	o := Orphan{
+		Name:    strings.TrimPrefix(r.Name, "/"),
+		Running: r.State.Running,
+		Ports:   make(map[int]int),
+	}
+
+	seed, err := strconv.Atoi(r.Config.Labels[labelSeed])
+	if err == nil {
+		o.Image = r.Config.Labels[labelImage]
+		o.Seed = seed
+	}
+
+	for containerPort, bindings := range r.HostConfig.PortBindings {
+		port, err := strconv.Atoi(strings.TrimSuffix(containerPort, "/tcp"))
+		if err != nil || len(bindings) == 0 {
+			continue
+		}
+		hostPort, err := strconv.Atoi(bindings[0].HostPort)
+		if err != nil {
+			continue
+		}
+		o.Ports[port] = hostPort
+	}
+
+	return o
+}
+
+type runtime interface {
+	listOwned() ([]inspectResponse, error)
+	remove(nameOrID string) error
+}
+
+// Inventory finds containers of previous runs and takes them under control.
+type Inventory struct {
+	l   *zap.SugaredLogger
+	cfg Config
+	r   runtime
+}
+
+// NewInventory creates Inventory for the docker runtime of the config.
+func NewInventory(l *zap.SugaredLogger, cfg Config) (*Inventory, error) {
+	err := cfg.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid containers config: %w", err)
+	}
+
+	var r runtime
+	if cfg.Runtime == RuntimeAPI {
+		r = newDockerAPI(l, cfg.DockerSocket, "", "", 0, 0, "", nil, nil)
+	} else {
+		r = newDocker(l, "", "", 0, 0, "", nil, nil)
+	}
+
+	return &Inventory{l: l, cfg: cfg, r: r}, nil
+}
+
+// List returns all containers with the owner label, running or not.
+func (i *Inventory) List() ([]Orphan, error) {
+	inspections, err := i.r.listOwned()
+	if err != nil {
+		return nil, err
+	}
+
+	orphans := make([]Orphan, 0, len(inspections))
+	for _, r := range inspections {
+		orphans = append(orphans, r.orphan())
+	}
+
+	return orphans, nil
+}
+
+// Remove force removes the orphan.
+func (i *Inventory) Remove(o Orphan) error {
+	err := i.r.remove(o.Name)
+	if err != nil {
+		return fmt.Errorf("cannot remove container %s: %w", o.Name, err)
+	}
+
+	return nil
+}
+
+// Adopt creates a ready Qual of the running orphan if it passes a health check.
+// The orphan is not touched on error.
+func (i *Inventory) Adopt(o Orphan, spec ImageSpec) (*Qual, error) {
+	if !o.Running {
+		return nil, fmt.Errorf("container %s is not running", o.Name)
+	}
+
+	port, ok := o.Ports[spec.Port]
+	if !ok {
+		return nil, fmt.Errorf("container %s does not publish port %d", o.Name, spec.Port)
+	}
+
+	q := newQual(i.l.Named("qual"), spec, o.Seed, i.cfg, port, o.Name)
+
+	resp, err := q.checkHealth(i.cfg.InitializationTimeout)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if err != nil {
+		q.closeFn()
+		return nil, fmt.Errorf("container %s is unhealthy: %w", o.Name, err)
+	}
+
+	q.stateMu.Lock()
+	q.started = true
+	q.setState(readyState)
+	q.stateMu.Unlock()
+
+	return q, nil
+}
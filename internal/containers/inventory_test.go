@@ -0,0 +1,81 @@
+package containers
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestInventory_ListRemove(t *testing.T) {
+	var removed []string
+	d := newTestDockerAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodDelete:
+			removed = append(removed, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		case r.URL.Path == "/v1.41/containers/json":
+			_, _ = w.Write([]byte(`[{"Id":"a","Names":["/qual_1_seed_1"]}]`))
+		case r.URL.Path == "/v1.41/containers/a/json":
+			_, _ = w.Write([]byte(`{"Name":"/qual_1_seed_1","Config":{"Labels":{"container_scheduler.seed":"x"}}}`))
+		}
+	}))
+	i := &Inventory{l: zap.NewNop().Sugar(), r: d}
+
+	got, err := i.List()
+
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, "qual_1_seed_1", got[0].Name)
+	assert.Empty(t, got[0].Image)
+
+	require.NoError(t, i.Remove(got[0]))
+	assert.Equal(t, []string{"/v1.41/containers/qual_1_seed_1"}, removed)
+}
+
+func TestInventory_Adopt(t *testing.T) {
+	healthy := true
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/health", r.URL.Path)
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer s.Close()
+	port := s.Listener.Addr().(*net.TCPAddr).Port
+
+	i := &Inventory{
+		l: zap.NewNop().Sugar(),
+		cfg: Config{
+			Runtime:               RuntimeCLI,
+			InitializationTimeout: time.Second,
+			CalculationTimeout:    time.Second,
+			IdleTimeout:           time.Minute,
+			HealthInterval:        time.Millisecond,
+		},
+	}
+	o := Orphan{Name: "qual_1_seed_1", Image: Qual2021.Name, Seed: 1, Running: true, Ports: map[int]int{Qual2021.Port: port}}
+
+	q, err := i.Adopt(o, Qual2021)
+	require.NoError(t, err)
+	assert.Equal(t, readyState, q.state)
+	assert.Equal(t, port, q.port)
+	assert.Equal(t, "qual_1_seed_1", q.name)
+	q.closeFn()
+
+	healthy = false
+	_, err = i.Adopt(o, Qual2021)
+	assert.Error(t, err)
+
+	_, err = i.Adopt(Orphan{Name: "qual_1_seed_1", Running: true}, Qual2021)
+	assert.Error(t, err)
+
+	o.Running = false
+	_, err = i.Adopt(o, Qual2021)
+	assert.Error(t, err)
+}
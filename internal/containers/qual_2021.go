@@ -5,25 +5,103 @@ package containers
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
 	"strconv"
 	"sync"
 	"time"
 
+	"github.com/Snyssfx/container_scheduler/internal/metrics"
+	"github.com/Snyssfx/container_scheduler/internal/progress"
+	"github.com/Snyssfx/container_scheduler/internal/snapshot"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
 )
 
 const (
-	initializationTimeout = 130 * time.Second
-	imageName             = "quay.io/milaboratory/qual-2021-devops-server"
-	imageTag              = "latest"
-	calculationTimeout    = 130 * time.Second
-	stopAfterTimeout      = 120 * time.Second
+	// namePrefix is a prefix of names of all containers of the scheduler.
+	namePrefix = "qual_"
+	// maxRetryBackoff is the longest pause between retries of a calculation.
+	maxRetryBackoff = 30 * time.Second
 )
 
+// Runtime is a way to control docker containers.
+type Runtime string
+
+const (
+	// RuntimeAPI uses Docker Engine HTTP API over a unix socket.
+	RuntimeAPI Runtime = "api"
+	// RuntimeCLI runs docker command line.
+	RuntimeCLI Runtime = "cli"
+)
+
+// Config holds settings of Qual containers.
+type Config struct {
+	Runtime      Runtime
+	DockerSocket string
+	// InitializationTimeout is a maximum time for a container to become healthy.
+	InitializationTimeout time.Duration
+	// CalculationTimeout is a maximum time of one calculation request.
+	CalculationTimeout time.Duration
+	// IdleTimeout is a time after the last calculation when the container is stopped.
+	// With AdaptiveIdle it is used until the first initialization is measured.
+	IdleTimeout time.Duration
+	// HealthInterval is a period of health checks during initialization.
+	HealthInterval time.Duration
+	// AdaptiveIdle chooses the idle timeout of every container from its initialization
+	// duration and gaps between calculations.
+	AdaptiveIdle bool
+	// MaxIdleTimeout is the longest adaptive idle timeout.
+	MaxIdleTimeout time.Duration
+	// RestartWeight is a cost of a restart in initialization durations of idle time.
+	RestartWeight float64
+	// Retries is a count of repeated calculations after 5xx statuses or connection errors.
+	Retries int
+	// RetryBackoff is a pause before the first retry, it doubles up to maxRetryBackoff.
+	RetryBackoff time.Duration
+	// UnhealthyFailures is a count of failed calculations in a row after which the container
+	// is restarted, 0 restarts only unreachable containers and ones failing a health probe.
+	UnhealthyFailures int
+}
+
+// Validate checks that the config is consistent.
+func (cfg Config) Validate() error {
+	switch cfg.Runtime {
+	case RuntimeAPI:
+		if cfg.DockerSocket == "" {
+			return fmt.Errorf("api runtime needs a docker socket")
+		}
+	case RuntimeCLI:
+	default:
+		return fmt.Errorf("unknown docker runtime %q", cfg.Runtime)
+	}
+
+	if cfg.InitializationTimeout <= 0 || cfg.CalculationTimeout <= 0 || cfg.IdleTimeout <= 0 || cfg.HealthInterval <= 0 {
+		return fmt.Errorf(
+			"timeouts should be positive, got initialization %s, calculation %s, idle %s, health interval %s",
+			cfg.InitializationTimeout, cfg.CalculationTimeout, cfg.IdleTimeout, cfg.HealthInterval,
+		)
+	}
+
+	if cfg.AdaptiveIdle && (cfg.MaxIdleTimeout < minAdaptiveIdle || cfg.RestartWeight <= 0) {
+		return fmt.Errorf(
+			"adaptive idle needs max idle timeout at least %s and positive restart weight, got %s and %v",
+			minAdaptiveIdle, cfg.MaxIdleTimeout, cfg.RestartWeight,
+		)
+	}
+
+	if cfg.Retries < 0 || cfg.UnhealthyFailures < 0 || (cfg.Retries > 0 && cfg.RetryBackoff <= 0) {
+		return fmt.Errorf(
+			"retries %d and unhealthy failures %d should not be negative, retries need positive backoff, got %s",
+			cfg.Retries, cfg.UnhealthyFailures, cfg.RetryBackoff,
+		)
+	}
+
+	return nil
+}
+
 type state int
 
 const (
@@ -32,20 +110,87 @@ const (
 	stoppedState
 )
 
-// Qual is a container that start docker container for a qualification,
+func (s state) String() string {
+	switch s {
+	case initState:
+		return snapshot.StateInit
+	case readyState:
+		return snapshot.StateReady
+	case stoppedState:
+		return snapshot.StateStopped
+	default:
+		return fmt.Sprintf("state(%d)", int(s))
+	}
+}
+
+// Qual is a container that start docker container of an image spec,
 // wait for initialization, send calculations to it and stops it after the
 // given time.
 type Qual struct {
-	l       *zap.SugaredLogger
-	d       container
-	port    int
-	name    string
-	client  client
-	closeFn context.CancelFunc
-
-	stateMu         sync.Mutex
+	l        *zap.SugaredLogger
+	d        container
+	spec     ImageSpec
+	seed     int
+	port     int
+	name     string
+	client   client
+	closeCtx context.Context
+	closeFn  context.CancelFunc
+
+	timeoutsMu sync.RWMutex
+	timeouts   timeouts
+	// pinned containers are not stopped after the idle timeout.
+	pinned atomic.Bool
+	// idleOverride replaces the idle timeout of the config if it is positive.
+	idleOverride atomic.Duration
+	idle         idlePolicy
+
+	// stateMu serializes starts and stops, it is held during an initialization.
+	stateMu sync.Mutex
+	// started is true if the container was run and is not removed yet, it is guarded by stateMu.
+	started bool
+	// statusMu guards the fields below for Status, they are changed under both mutexes.
+	statusMu        sync.Mutex
 	state           state
 	lastCalculation time.Time
+	// inFlight is a count of running calculations, the container is not stopped during them.
+	inFlight int
+	// generation is a count of starts, a restart of an unhealthy container is skipped if
+	// it was already restarted by another calculation.
+	generation int
+	// failures is a count of failed calculations in a row.
+	failures atomic.Int64
+}
+
+// timeouts are settings of a Qual that can be changed while it is running.
+type timeouts struct {
+	initialization time.Duration
+	calculation    time.Duration
+	idle           time.Duration
+	healthInterval time.Duration
+	adaptiveIdle   bool
+	maxIdle        time.Duration
+	restartWeight  float64
+
+	retries           int
+	retryBackoff      time.Duration
+	unhealthyFailures int
+}
+
+func newTimeouts(cfg Config) timeouts {
+	return timeouts{
+		initialization: cfg.InitializationTimeout,
+		calculation:    cfg.CalculationTimeout,
+		idle:           cfg.IdleTimeout,
+		healthInterval: cfg.HealthInterval,
+		adaptiveIdle:   cfg.AdaptiveIdle,
+		maxIdle:        cfg.MaxIdleTimeout,
+		restartWeight:  cfg.RestartWeight,
+
+		retries:           cfg.Retries,
+		retryBackoff:      cfg.RetryBackoff,
+		unhealthyFailures: cfg.UnhealthyFailures,
+	}
 }
 
 type container interface {
@@ -55,40 +200,72 @@ type container interface {
 
 type client interface {
 	Do(*http.Request) (*http.Response, error)
-	Get(url string) (*http.Response, error)
 }
 
 // NewQual creates new Qual.
-func NewQual(l *zap.SugaredLogger, seed int) (*Qual, error) {
+func NewQual(l *zap.SugaredLogger, spec ImageSpec, seed int, cfg Config) (*Qual, error) {
+	err := cfg.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid containers config: %w", err)
+	}
+
 	port, err := getFreePort()
 	if err != nil {
 		return nil, fmt.Errorf("cannot get free port: %w", err)
 	}
 
-	name := fmt.Sprintf("qual_%d_seed_%d", port, seed)
+	return newQual(l, spec, seed, cfg, port, fmt.Sprintf("%s%d_seed_%d", namePrefix, port, seed)), nil
+}
+
+// newQual creates a Qual of a container with the given name and port that may already exist.
+func newQual(l *zap.SugaredLogger, spec ImageSpec, seed int, cfg Config, port int, name string) *Qual {
+	envs := spec.envs(seed)
+	labels := ownerLabels(spec, seed)
+
+	var d container
+	if cfg.Runtime == RuntimeAPI {
+		d = newDockerAPI(l.Named("d"), cfg.DockerSocket, spec.Image, spec.Tag, port, spec.Port, name, envs, labels)
+	} else {
+		d = newDocker(l.Named("d"), spec.Image, spec.Tag, port, spec.Port, name, envs, labels)
+	}
+
 	ctx, cancelFn := context.WithCancel(context.Background())
 
 	q := &Qual{
-		l: l,
-		d: newDocker(
-			l.Named("d"),
-			imageName, imageTag,
-			port,
-			name,
-			[][]string{{"SEED", strconv.Itoa(seed)}},
-		),
-		name:    name,
-		client:  &http.Client{Timeout: calculationTimeout},
-		port:    port,
-		closeFn: cancelFn,
-
-		stateMu: sync.Mutex{},
-		state:   initState,
+		l:        l,
+		d:        d,
+		spec:     spec,
+		seed:     seed,
+		name:     name,
+		client:   &http.Client{},
+		port:     port,
+		closeCtx: ctx,
+		closeFn:  cancelFn,
+
+		timeoutsMu: sync.RWMutex{},
+		timeouts:   newTimeouts(cfg),
+
+		stateMu:         sync.Mutex{},
+		statusMu:        sync.Mutex{},
+		state:           initState,
+		lastCalculation: time.Now(),
 	}
 
-	go q.stopAfter(ctx, stopAfterTimeout)
+	go q.stopAfter(ctx)
 
-	return q, nil
+	return q
+}
+
+// RemoveAll force removes all containers of the scheduler including ones of previous runs.
+func RemoveAll(l *zap.SugaredLogger, cfg Config) error {
+	switch cfg.Runtime {
+	case RuntimeAPI:
+		return newDockerAPI(l, cfg.DockerSocket, "", "", 0, 0, "", nil, nil).removeAll(namePrefix)
+	case RuntimeCLI:
+		return newDocker(l, "", "", 0, 0, "", nil, nil).removeAll(namePrefix)
+	default:
+		return fmt.Errorf("unknown docker runtime %q", cfg.Runtime)
+	}
 }
 
 func getFreePort() (int, error) {
@@ -111,51 +288,258 @@ func getFreePort() (int, error) {
 }
 
 // Calculate starts the container if it is stopped, and send a request for a calculation.
+// Calculations that failed with 5xx statuses or connection errors are retried with
+// an exponential backoff, an unhealthy container is restarted before the retry.
 func (q *Qual) Calculate(ctx context.Context, input int) (int, error) {
 	q.stateMu.Lock()
-	if q.state != readyState {
-		err := q.start()
-		if err != nil {
-			q.stateMu.Unlock()
-			return 0, fmt.Errorf("cannot start a container: %w", err)
+	// a gap is a time the container had to stay idle to serve this calculation without a restart
+	if q.state != initState && q.inFlight == 0 {
+		q.idle.observeGap(time.Since(q.lastCalculation))
+	}
+	q.statusMu.Lock()
+	q.inFlight++
+	q.statusMu.Unlock()
+	q.stateMu.Unlock()
+	defer q.finishCalculation()
+
+	t := q.getTimeouts()
+	backoff := t.retryBackoff
+	for attempt := 0; ; attempt++ {
+		result, err := q.calculate(ctx, input)
+		if err == nil || !Retryable(err) || attempt >= t.retries {
+			return result, err
+		}
+
+		q.l.Warnf("retry input %d in %s after attempt %d: %s", input, backoff, attempt+1, err.Error())
+		metrics.QualRetries.Inc()
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return 0, err
+		case <-q.closeCtx.Done():
+			return 0, err
+		}
+
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
 		}
-		q.state = readyState
 	}
+}
+
+// calculate starts the container if it is stopped and sends one request for a calculation.
+func (q *Qual) calculate(ctx context.Context, input int) (int, error) {
+	q.stateMu.Lock()
+	err := q.ensureStarted(ctx)
+	generation := q.generation
 	q.stateMu.Unlock()
+	if err != nil {
+		return 0, err
+	}
 
+	progress.Report(ctx, progress.Event{Stage: progress.StageCalculating})
 	q.l.Infof("get request for input %d", input)
-	req, err := http.NewRequest("GET", fmt.Sprintf("http://127.0.0.1:%d/calculate/%d", q.port, input), nil)
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://127.0.0.1:%d%s", q.port, q.spec.calculatePath(input)), nil)
 	if err != nil {
 		return 0, fmt.Errorf("cannot create request: %w", err)
 	}
-	req = req.WithContext(ctx)
+	reqCtx, cancelFn := context.WithTimeout(ctx, q.getTimeouts().calculation)
+	defer cancelFn()
+	req = req.WithContext(reqCtx)
 
 	resp, err := q.client.Do(req)
-	if err != nil {
+	switch {
+	case err != nil && ctx.Err() != nil:
 		return 0, fmt.Errorf("cannot do request: %w", err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return 0, withKind(ErrCalculationTimeout, fmt.Errorf("cannot do request: %w", err))
+	case err != nil:
+		err = withKind(ErrUnreachable, fmt.Errorf("cannot do request: %w", err))
+		q.restartUnhealthy(generation, err)
+		return 0, err
 	}
 
-	bytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return 0, fmt.Errorf("cannot read body: %w", err)
+	result, err := q.spec.readResult(resp)
+	if Retryable(err) {
+		q.checkHealthAfter(generation, err)
+		return 0, err
 	}
+	q.failures.Store(0)
 
-	result, err := strconv.Atoi(string(bytes))
+	return result, err
+}
+
+// checkHealthAfter counts a failed calculation and restarts the container if it failed
+// too many times in a row or does not pass a health probe.
+func (q *Qual) checkHealthAfter(generation int, err error) {
+	failures := int(q.failures.Inc())
+	if threshold := q.getTimeouts().unhealthyFailures; threshold > 0 && failures >= threshold {
+		q.restartUnhealthy(generation, fmt.Errorf("%d failures in a row, the last one: %w", failures, err))
+		return
+	}
+
+	resp, errHealth := q.checkHealth(q.getTimeouts().healthInterval)
+	if errHealth != nil {
+		q.restartUnhealthy(generation, fmt.Errorf("health probe failed: %w", errHealth))
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		q.restartUnhealthy(generation, fmt.Errorf("health probe returned status %d", resp.StatusCode))
+	}
+}
+
+// restartUnhealthy stops the container of the given start, the next calculation starts it again.
+func (q *Qual) restartUnhealthy(generation int, reason error) {
+	q.stateMu.Lock()
+	defer q.stateMu.Unlock()
+
+	if q.generation != generation || q.state != readyState {
+		return
+	}
+
+	q.l.Warnf("%s is unhealthy and will be restarted: %s", q.name, reason.Error())
+	q.failures.Store(0)
+	metrics.QualRestarts.Inc()
+	err := q.stop()
 	if err != nil {
-		return 0, fmt.Errorf("cannot parse body %q: %w", string(bytes), err)
+		// the container may be still running, so the idle loop and Close try to stop it again
+		// and the next start replaces it
+		q.l.Errorf("cannot stop unhealthy %s: %s", q.name, err.Error())
+		q.setState(initState)
 	}
+}
 
+// finishCalculation starts the idle time of the container after its last calculation.
+func (q *Qual) finishCalculation() {
+	q.stateMu.Lock()
+	defer q.stateMu.Unlock()
+
+	q.statusMu.Lock()
+	q.inFlight--
 	q.lastCalculation = time.Now()
-	return result, nil
+	q.statusMu.Unlock()
+}
+
+// Status returns a snapshot of the container, it does not wait for an initialization.
+func (q *Qual) Status() snapshot.Container {
+	idle := q.idleTimeout()
+
+	q.statusMu.Lock()
+	defer q.statusMu.Unlock()
+
+	return snapshot.Container{
+		Name:            q.name,
+		Port:            q.port,
+		State:           q.state.String(),
+		Pinned:          q.pinned.Load(),
+		InFlight:        q.inFlight,
+		LastCalculation: q.lastCalculation,
+		IdleTimeout:     idle.String(),
+	}
+}
+
+// setState changes the state. It is called under the state mutex.
+func (q *Qual) setState(s state) {
+	q.statusMu.Lock()
+	q.state = s
+	q.statusMu.Unlock()
+}
+
+// Warm starts the container if it is not ready and waits for its initialization.
+func (q *Qual) Warm() error {
+	q.stateMu.Lock()
+	defer q.stateMu.Unlock()
+
+	return q.ensureStarted(context.Background())
+}
+
+// SetPinned exempts the container from stopping after the idle timeout or returns it back.
+func (q *Qual) SetPinned(pinned bool) {
+	q.pinned.Store(pinned)
+}
+
+// SetIdleTimeout overrides the idle timeout of this container, 0 returns the adaptive one
+// or the one of the config.
+func (q *Qual) SetIdleTimeout(idle time.Duration) {
+	q.idleOverride.Store(idle)
+}
+
+// idleTimeout returns the override, the adaptive idle timeout or the one of the config.
+func (q *Qual) idleTimeout() time.Duration {
+	if idle := q.idleOverride.Load(); idle > 0 {
+		return idle
+	}
+
+	t := q.getTimeouts()
+	if !t.adaptiveIdle {
+		return t.idle
+	}
+
+	return q.idle.timeout(t.idle, t.maxIdle, t.restartWeight)
+}
+
+// ensureStarted starts the container if it is not ready. It is called under the state mutex.
+func (q *Qual) ensureStarted(ctx context.Context) error {
+	if q.state == readyState {
+		return nil
+	}
+
+	progress.Report(ctx, progress.Event{Stage: progress.StageStarting})
+	startedAt := time.Now()
+	err := q.start(ctx)
+	if err != nil {
+		metrics.QualStarts.WithLabelValues("error").Inc()
+		// the container may be running after a failed initialization, it is stopped
+		// so that the next start can run a new one with the same name
+		errStop := q.stop()
+		if errStop != nil {
+			q.l.Errorf("cannot stop %s after a failed start: %s", q.name, errStop.Error())
+		}
+		return fmt.Errorf("cannot start a container: %w", err)
+	}
+
+	q.setState(readyState)
+	q.generation++
+	initDuration := time.Since(startedAt)
+	q.idle.observeInit(initDuration)
+	metrics.QualStarts.WithLabelValues("ok").Inc()
+	metrics.QualInitDuration.Observe(initDuration.Seconds())
+	return nil
+}
+
+// SetConfig applies timeouts of the config to the running Qual: the next calculation,
+// initialization and idle check use them. A runtime and a socket cannot be changed.
+func (q *Qual) SetConfig(cfg Config) error {
+	err := cfg.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid containers config: %w", err)
+	}
+
+	q.timeoutsMu.Lock()
+	q.timeouts = newTimeouts(cfg)
+	q.timeoutsMu.Unlock()
+
+	return nil
+}
+
+func (q *Qual) getTimeouts() timeouts {
+	q.timeoutsMu.RLock()
+	defer q.timeoutsMu.RUnlock()
+
+	return q.timeouts
 }
 
 // Close closes underlying Docker container and stops the lifecycle loop.
+// An initialization in progress is interrupted.
 func (q *Qual) Close() error {
 	q.l.Debugf("try to stop %s", q.name)
 
 	q.closeFn()
 
-	err := q.d.Stop()
+	q.stateMu.Lock()
+	defer q.stateMu.Unlock()
+
+	err := q.stop()
 	if err != nil {
 		return fmt.Errorf("cannot stop docker container: %w", err)
 	}
@@ -164,24 +548,31 @@ func (q *Qual) Close() error {
 	return nil
 }
 
-// start runs the container and waits for full initialization.
-func (q *Qual) start() error {
+// start runs the container and waits for full initialization. The context is used
+// for progress reports only, the initialization is not interrupted by it.
+func (q *Qual) start(ctx context.Context) error {
+	q.statusMu.Lock()
 	q.lastCalculation = time.Now()
+	q.statusMu.Unlock()
 
+	q.started = true
 	err := q.d.Run()
 	if err != nil {
-		return fmt.Errorf("cannot run docker container: %w", err)
+		return withKind(ErrStart, fmt.Errorf("cannot run docker container: %w", err))
 	}
 
-	ticker := time.NewTicker(2 * time.Second)
+	t := q.getTimeouts()
+	ticker := time.NewTicker(t.healthInterval)
 	defer ticker.Stop()
 
-	for {
+	timeout := time.After(t.initialization)
+	for attempt := 1; ; attempt++ {
 		select {
 		case <-ticker.C:
-			resp, err := q.client.Get(fmt.Sprintf("http://127.0.0.1:%d/health", q.port))
+			progress.Report(ctx, progress.Event{Stage: progress.StageHealthCheck, Attempt: attempt})
+			resp, err := q.checkHealth(t.healthInterval)
 			if err != nil {
-				q.l.Debugf("%s: /health: %s", q.name, err.Error())
+				q.l.Debugf("%s: %s: %s", q.name, q.spec.HealthPath, err.Error())
 				break
 			}
 
@@ -190,39 +581,80 @@ func (q *Qual) start() error {
 				return nil
 			}
 
-		case <-time.After(initializationTimeout):
-			return fmt.Errorf("container was intializing for too long")
+		case <-timeout:
+			return fmt.Errorf("%w: %s", ErrInitTimeout, t.initialization)
+
+		case <-q.closeCtx.Done():
+			return fmt.Errorf("%w during initialization", ErrClosed)
 		}
 	}
 }
 
-// stopAfter waits given time after last calculation and stops the container.
-func (q *Qual) stopAfter(ctx context.Context, after time.Duration) {
+// stop stops the container if it was run and is not stopped yet. A container that failed
+// to initialize may be running, so it is stopped too. It is called under the state mutex.
+func (q *Qual) stop() error {
+	if !q.started {
+		return nil
+	}
+
+	err := q.d.Stop()
+	if err != nil {
+		return err
+	}
+	metrics.QualStops.Inc()
+	q.started = false
+	q.setState(stoppedState)
+
+	return nil
+}
+
+// checkHealth requests the health path of the container.
+func (q *Qual) checkHealth(timeout time.Duration) (*http.Response, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), timeout)
+	defer cancelFn()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("http://127.0.0.1:%d%s", q.port, q.spec.HealthPath), nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create request: %w", err)
+	}
+
+	resp, err := q.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+
+	return resp, nil
+}
+
+// stopAfter waits the idle timeout after last calculation and stops the container.
+func (q *Qual) stopAfter(ctx context.Context) {
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			if time.Since(q.lastCalculation) <= after {
+			idle := q.idleTimeout()
+			metrics.QualIdleTimeout.WithLabelValues(q.spec.Name, strconv.Itoa(q.seed)).Set(idle.Seconds())
+
+			q.stateMu.Lock()
+			if q.pinned.Load() || q.inFlight > 0 || time.Since(q.lastCalculation) <= idle {
+				q.stateMu.Unlock()
 				continue
 			}
 
-			q.stateMu.Lock()
-			if q.state == readyState {
+			if q.started {
 				q.l.Debugf("try to stop in loop %s", q.name)
-				err := q.d.Stop()
-				if err != nil {
-					q.stateMu.Unlock()
-					q.l.Errorf("cannot stop the container: %s", err.Error())
-					continue
-				}
 			}
-
-			q.state = stoppedState
+			err := q.stop()
 			q.stateMu.Unlock()
+			if err != nil {
+				q.l.Errorf("cannot stop the container: %s", err.Error())
+			}
 
 		case <-ctx.Done():
+			metrics.QualIdleTimeout.DeleteLabelValues(q.spec.Name, strconv.Itoa(q.seed))
 			return
 		}
 	}
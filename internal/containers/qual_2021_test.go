@@ -6,13 +6,17 @@ package containers
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
 	"net/http"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/Snyssfx/container_scheduler/internal/containers/mock"
+	"github.com/Snyssfx/container_scheduler/internal/progress"
+	"github.com/Snyssfx/container_scheduler/internal/snapshot"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
@@ -22,31 +26,282 @@ func TestQual_Calculate(t *testing.T) {
 	d := mock.NewContainerMock(t)
 	d.RunMock.Return(nil)
 	client := mock.NewClientMock(t)
-	client.GetMock.Set(func(string) (rp1 *http.Response, err error) {
-		return &http.Response{StatusCode: 200}, nil
-	})
 	client.DoMock.Set(func(rp1 *http.Request) (rp2 *http.Response, err error) {
+		if rp1.URL.Path == "/health" {
+			return &http.Response{StatusCode: 200, Body: http.NoBody}, nil
+		}
+		assert.Equal(t, "/calculate/1", rp1.URL.Path)
 		return &http.Response{
-			Body: io.NopCloser(bytes.NewReader([]byte(`2`))),
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewReader([]byte(`2`))),
 		}, nil
 	})
 	q := &Qual{
 		l:               zap.NewNop().Sugar(),
 		d:               d,
+		spec:            Qual2021,
 		port:            9090,
 		name:            "qual_9090_seed_123",
 		client:          client,
+		closeCtx:        context.Background(),
 		closeFn:         nil,
+		timeouts:        timeouts{initialization: time.Second, calculation: time.Second, healthInterval: time.Millisecond},
 		stateMu:         sync.Mutex{},
 		state:           initState,
 		lastCalculation: time.Time{},
 	}
 
-	got, err := q.Calculate(context.Background(), 1)
+	var events []progress.Event
+	ctx := progress.WithReporter(context.Background(), func(e progress.Event) { events = append(events, e) })
+
+	got, err := q.Calculate(ctx, 1)
 
 	require.NoError(t, err)
 	assert.Equal(t, readyState, q.state)
 	assert.Equal(t, 2, got)
+	assert.Equal(t, []progress.Event{
+		{Stage: progress.StageStarting},
+		{Stage: progress.StageHealthCheck, Attempt: 1},
+		{Stage: progress.StageCalculating},
+	}, events)
+	assert.Equal(t, 0, q.inFlight)
+	assert.NotZero(t, q.idle.initDuration)
+	assert.Empty(t, q.idle.gaps, "the container was not started before")
+
+	_, err = q.Calculate(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Len(t, q.idle.gaps, 1)
+}
+
+func TestQual_Calculate_Errors(t *testing.T) {
+	errDocker := errors.New("docker is down")
+	for name, tc := range map[string]struct {
+		runErr error
+		health int
+		do     func(*http.Request) (*http.Response, error)
+		want   error
+	}{
+		"start": {runErr: errDocker, want: ErrStart},
+		"init timeout": {
+			health: http.StatusServiceUnavailable,
+			want:   ErrInitTimeout,
+		},
+		"unreachable": {
+			do:   func(*http.Request) (*http.Response, error) { return nil, errors.New("connection refused") },
+			want: ErrUnreachable,
+		},
+		"calculation timeout": {
+			do: func(r *http.Request) (*http.Response, error) {
+				<-r.Context().Done()
+				return nil, r.Context().Err()
+			},
+			want: ErrCalculationTimeout,
+		},
+		"bad body": {
+			do: func(*http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: 200, Body: io.NopCloser(bytes.NewReader([]byte(`two`)))}, nil
+			},
+			want: ErrBadUpstreamResponse,
+		},
+	} {
+		d := mock.NewContainerMock(t)
+		d.RunMock.Return(tc.runErr)
+		d.StopMock.Return(nil)
+		client := mock.NewClientMock(t)
+		client.DoMock.Set(func(r *http.Request) (*http.Response, error) {
+			if r.URL.Path == "/health" {
+				status := tc.health
+				if status == 0 {
+					status = http.StatusOK
+				}
+				return &http.Response{StatusCode: status, Body: http.NoBody}, nil
+			}
+			return tc.do(r)
+		})
+		q := &Qual{
+			l:        zap.NewNop().Sugar(),
+			d:        d,
+			spec:     Qual2021,
+			client:   client,
+			closeCtx: context.Background(),
+			timeouts: timeouts{initialization: 20 * time.Millisecond, calculation: 20 * time.Millisecond, healthInterval: time.Millisecond},
+			state:    initState,
+		}
+
+		_, err := q.Calculate(context.Background(), 1)
+
+		assert.ErrorIs(t, err, tc.want, name)
+		if tc.runErr != nil {
+			assert.ErrorIs(t, err, tc.runErr, name)
+		}
+	}
+}
+
+// closeTracker is a response body that records whether it was closed.
+type closeTracker struct {
+	io.Reader
+	closed bool
+}
+
+func (b *closeTracker) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestQual_Calculate_Response(t *testing.T) {
+	for name, tc := range map[string]struct {
+		status    int
+		body      string
+		want      int
+		wantErr   error
+		retryable bool
+	}{
+		"ok":            {status: http.StatusOK, body: "42\n", want: 42},
+		"bad input":     {status: http.StatusBadRequest, body: "input is too large", wantErr: ErrBadInput},
+		"unprocessable": {status: http.StatusUnprocessableEntity, body: "not a number", wantErr: ErrBadInput},
+		"timeout":       {status: http.StatusRequestTimeout, wantErr: ErrUpstreamFailure, retryable: true},
+		"throttled":     {status: http.StatusTooManyRequests, wantErr: ErrUpstreamFailure, retryable: true},
+		"not found":     {status: http.StatusNotFound, wantErr: ErrUpstreamFailure, retryable: true},
+		"server error":  {status: http.StatusInternalServerError, body: "panic", wantErr: ErrUpstreamFailure, retryable: true},
+		"redirect":      {status: http.StatusNotModified, wantErr: ErrUpstreamFailure, retryable: true},
+		"bad body":      {status: http.StatusOK, body: "<html>", wantErr: ErrBadUpstreamResponse},
+		"large body":    {status: http.StatusOK, body: strings.Repeat("1", maxResponseSize+1), wantErr: ErrBadUpstreamResponse},
+	} {
+		body := &closeTracker{Reader: strings.NewReader(tc.body)}
+		client := mock.NewClientMock(t)
+		client.DoMock.Set(func(r *http.Request) (*http.Response, error) {
+			if r.URL.Path == "/health" {
+				return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+			}
+			return &http.Response{StatusCode: tc.status, Body: body}, nil
+		})
+		q := &Qual{
+			l:        zap.NewNop().Sugar(),
+			spec:     Qual2021,
+			client:   client,
+			timeouts: timeouts{calculation: time.Second},
+			state:    readyState,
+		}
+
+		got, err := q.Calculate(context.Background(), 1)
+
+		assert.True(t, body.closed, name)
+		if tc.wantErr != nil {
+			assert.ErrorIs(t, err, tc.wantErr, name)
+			assert.Equal(t, tc.retryable, Retryable(err), name)
+			assert.Less(t, len(err.Error()), 2*maxErrorBodySize, name)
+			continue
+		}
+		require.NoError(t, err, name)
+		assert.Equal(t, tc.want, got, name)
+	}
+}
+
+func TestQual_Calculate_Retry(t *testing.T) {
+	refused := errors.New("connection refused")
+	for name, tc := range map[string]struct {
+		responses         []int // 0 means a connection error
+		retries           int
+		unhealthyFailures int
+		wantErr           error
+		wantCalls         int
+		wantStops         uint64
+	}{
+		"recovered":        {responses: []int{500, 503, 200}, retries: 2, wantCalls: 3},
+		"exhausted":        {responses: []int{500, 500, 500}, retries: 2, wantErr: ErrUpstreamFailure, wantCalls: 3},
+		"bad input":        {responses: []int{400, 200}, retries: 2, wantErr: ErrBadInput, wantCalls: 1},
+		"crashed":          {responses: []int{0, 200}, retries: 1, wantCalls: 2, wantStops: 1},
+		"repeated failure": {responses: []int{500, 500}, retries: 1, unhealthyFailures: 2, wantErr: ErrUpstreamFailure, wantCalls: 2, wantStops: 1},
+	} {
+		d := mock.NewContainerMock(t)
+		d.RunMock.Return(nil)
+		d.StopMock.Return(nil)
+		var calls int
+		client := mock.NewClientMock(t)
+		client.DoMock.Set(func(r *http.Request) (*http.Response, error) {
+			if r.URL.Path == "/health" {
+				return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+			}
+			status := tc.responses[calls]
+			calls++
+			if status == 0 {
+				return nil, refused
+			}
+			return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader("7"))}, nil
+		})
+		q := &Qual{
+			l:        zap.NewNop().Sugar(),
+			d:        d,
+			spec:     Qual2021,
+			client:   client,
+			closeCtx: context.Background(),
+			timeouts: timeouts{
+				initialization: time.Second, calculation: time.Second, healthInterval: time.Millisecond,
+				retries: tc.retries, retryBackoff: time.Millisecond, unhealthyFailures: tc.unhealthyFailures,
+			},
+			state:   readyState,
+			started: true,
+		}
+
+		got, err := q.Calculate(context.Background(), 1)
+
+		assert.Equal(t, tc.wantCalls, calls, name)
+		assert.Equal(t, tc.wantStops, d.StopAfterCounter(), name)
+		if tc.wantErr != nil {
+			assert.ErrorIs(t, err, tc.wantErr, name)
+			continue
+		}
+		require.NoError(t, err, name)
+		assert.Equal(t, 7, got, name)
+		assert.Equal(t, readyState, q.state, name)
+		assert.Zero(t, q.failures.Load(), name)
+	}
+}
+
+func TestQual_Calculate_RetryAfterFailedStart(t *testing.T) {
+	var running, healthy bool
+	d := mock.NewContainerMock(t)
+	d.RunMock.Set(func() error {
+		if running {
+			return errors.New("name is already in use")
+		}
+		running = true
+		return nil
+	})
+	d.StopMock.Set(func() error {
+		running = false
+		return nil
+	})
+	client := mock.NewClientMock(t)
+	client.DoMock.Set(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Path == "/health" {
+			if !healthy {
+				return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: http.NoBody}, nil
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("2"))}, nil
+	})
+	q := &Qual{
+		l:        zap.NewNop().Sugar(),
+		d:        d,
+		spec:     Qual2021,
+		client:   client,
+		closeCtx: context.Background(),
+		timeouts: timeouts{initialization: 20 * time.Millisecond, calculation: time.Second, healthInterval: time.Millisecond},
+		state:    initState,
+	}
+
+	_, err := q.Calculate(context.Background(), 1)
+	require.ErrorIs(t, err, ErrInitTimeout)
+	assert.Equal(t, stoppedState, q.state)
+	assert.False(t, running, "the container of the failed start is stopped")
+
+	healthy = true
+	got, err := q.Calculate(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, got)
+	assert.Equal(t, uint64(2), d.RunAfterCounter())
 }
 
 func TestQual_stopAfter(t *testing.T) {
@@ -55,17 +310,230 @@ func TestQual_stopAfter(t *testing.T) {
 	q := &Qual{
 		l:               zap.L().Sugar(),
 		d:               d,
+		spec:            Qual2021,
 		port:            9090,
 		name:            "qual_9090_seed_123",
 		client:          nil,
+		closeCtx:        context.Background(),
 		closeFn:         nil,
+		timeouts:        timeouts{idle: time.Microsecond},
 		stateMu:         sync.Mutex{},
 		state:           readyState,
+		started:         true,
 		lastCalculation: time.Now().Add(-1 * time.Second),
 	}
 
 	ctx, cancelFn := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancelFn()
 
-	q.stopAfter(ctx, 1*time.Microsecond)
+	q.stopAfter(ctx)
+}
+
+func TestQual_SetConfig(t *testing.T) {
+	q := &Qual{timeouts: timeouts{idle: time.Minute}}
+	cfg := Config{
+		Runtime:               RuntimeCLI,
+		InitializationTimeout: time.Second,
+		CalculationTimeout:    2 * time.Second,
+		IdleTimeout:           3 * time.Second,
+		HealthInterval:        4 * time.Second,
+	}
+
+	require.NoError(t, q.SetConfig(cfg))
+	assert.Equal(t, timeouts{
+		initialization: time.Second,
+		calculation:    2 * time.Second,
+		idle:           3 * time.Second,
+		healthInterval: 4 * time.Second,
+	}, q.getTimeouts())
+
+	cfg.IdleTimeout = 0
+	require.Error(t, q.SetConfig(cfg))
+	assert.Equal(t, 3*time.Second, q.getTimeouts().idle)
+
+	// an override of the seed survives a new config
+	q.SetIdleTimeout(time.Hour)
+	require.NoError(t, q.SetConfig(Config{
+		Runtime:               RuntimeCLI,
+		InitializationTimeout: time.Second,
+		CalculationTimeout:    time.Second,
+		IdleTimeout:           time.Second,
+		HealthInterval:        time.Second,
+	}))
+	assert.Equal(t, time.Hour, q.idleTimeout())
+	q.SetIdleTimeout(0)
+	assert.Equal(t, time.Second, q.idleTimeout())
+}
+
+func TestQual_idleTimeout_Adaptive(t *testing.T) {
+	q := &Qual{timeouts: timeouts{idle: time.Minute, adaptiveIdle: true, maxIdle: time.Hour, restartWeight: 2}}
+	assert.Equal(t, time.Minute, q.idleTimeout())
+
+	q.idle.observeInit(5 * time.Minute)
+	assert.Equal(t, 10*time.Minute, q.idleTimeout())
+
+	q.SetIdleTimeout(time.Second)
+	assert.Equal(t, time.Second, q.idleTimeout())
+}
+
+func TestQual_Status(t *testing.T) {
+	last := time.Now()
+	q := &Qual{
+		name:            "qual_9090_seed_123",
+		port:            9090,
+		timeouts:        timeouts{idle: time.Minute},
+		state:           readyState,
+		lastCalculation: last,
+		inFlight:        2,
+	}
+	q.SetPinned(true)
+
+	// an initialization in progress does not block the status
+	q.stateMu.Lock()
+	defer q.stateMu.Unlock()
+
+	assert.Equal(t, snapshot.Container{
+		Name:            "qual_9090_seed_123",
+		Port:            9090,
+		State:           "ready",
+		Pinned:          true,
+		InFlight:        2,
+		LastCalculation: last,
+		IdleTimeout:     "1m0s",
+	}, q.Status())
+}
+
+func TestQual_Close(t *testing.T) {
+	for _, tc := range []struct {
+		state   state
+		started bool
+		stops   bool
+	}{
+		{state: initState, started: false, stops: false},
+		{state: initState, started: true, stops: true},
+		{state: readyState, started: true, stops: true},
+		{state: stoppedState, started: false, stops: false},
+	} {
+		d := mock.NewContainerMock(t)
+		if tc.stops {
+			d.StopMock.Return(nil)
+		}
+		ctx, cancelFn := context.WithCancel(context.Background())
+		q := &Qual{
+			l:        zap.NewNop().Sugar(),
+			d:        d,
+			name:     "qual_9090_seed_123",
+			closeCtx: ctx,
+			closeFn:  cancelFn,
+			state:    tc.state,
+			started:  tc.started,
+		}
+
+		require.NoError(t, q.Close())
+		assert.Equal(t, tc.stops, d.StopAfterCounter() == 1)
+		assert.False(t, q.started)
+		assert.Error(t, ctx.Err())
+	}
+}
+
+func TestQual_Close_AfterFailedInit(t *testing.T) {
+	d := mock.NewContainerMock(t)
+	d.RunMock.Return(nil)
+	d.StopMock.Return(nil)
+	client := mock.NewClientMock(t)
+	client.DoMock.Return(&http.Response{StatusCode: http.StatusServiceUnavailable, Body: http.NoBody}, nil)
+	ctx, cancelFn := context.WithCancel(context.Background())
+	q := &Qual{
+		l:        zap.NewNop().Sugar(),
+		d:        d,
+		spec:     Qual2021,
+		name:     "qual_9090_seed_123",
+		client:   client,
+		closeCtx: ctx,
+		closeFn:  cancelFn,
+		timeouts: timeouts{
+			initialization: 20 * time.Millisecond, calculation: time.Second, healthInterval: time.Millisecond,
+			idle: time.Microsecond,
+		},
+		state: initState,
+	}
+
+	_, err := q.Calculate(context.Background(), 1)
+	require.ErrorIs(t, err, ErrInitTimeout)
+
+	// the container of the failed initialization may be running, the idle loop stops it
+	loopCtx, loopCancelFn := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer loopCancelFn()
+	q.stopAfter(loopCtx)
+	assert.Equal(t, stoppedState, q.state)
+
+	require.NoError(t, q.Close())
+	assert.Equal(t, uint64(1), d.StopAfterCounter())
+}
+
+func TestQual_stopAfter_NeverStarted(t *testing.T) {
+	q := &Qual{
+		l:               zap.NewNop().Sugar(),
+		d:               mock.NewContainerMock(t),
+		name:            "qual_9090_seed_123",
+		closeCtx:        context.Background(),
+		timeouts:        timeouts{idle: time.Microsecond},
+		state:           initState,
+		lastCalculation: time.Now().Add(-1 * time.Second),
+	}
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancelFn()
+
+	// the mock fails the test if the container that was never run is stopped
+	q.stopAfter(ctx)
+	assert.Equal(t, initState, q.state)
+}
+
+func TestQual_stopAfter_Pinned(t *testing.T) {
+	q := &Qual{
+		l:               zap.NewNop().Sugar(),
+		d:               mock.NewContainerMock(t),
+		name:            "qual_9090_seed_123",
+		closeCtx:        context.Background(),
+		timeouts:        timeouts{idle: time.Microsecond},
+		state:           readyState,
+		started:         true,
+		lastCalculation: time.Now().Add(-1 * time.Second),
+	}
+	q.SetPinned(true)
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancelFn()
+
+	q.stopAfter(ctx)
+	assert.Equal(t, readyState, q.state)
+}
+
+func TestQual_Warm(t *testing.T) {
+	d := mock.NewContainerMock(t)
+	d.RunMock.Return(nil)
+	client := mock.NewClientMock(t)
+	client.DoMock.Set(func(rp1 *http.Request) (rp2 *http.Response, err error) {
+		assert.Equal(t, "/health", rp1.URL.Path)
+		return &http.Response{StatusCode: 200, Body: http.NoBody}, nil
+	})
+	q := &Qual{
+		l:        zap.NewNop().Sugar(),
+		d:        d,
+		spec:     Qual2021,
+		port:     9090,
+		name:     "qual_9090_seed_123",
+		client:   client,
+		closeCtx: context.Background(),
+		timeouts: timeouts{initialization: time.Second, healthInterval: time.Millisecond},
+		state:    stoppedState,
+	}
+
+	require.NoError(t, q.Warm())
+	assert.Equal(t, readyState, q.state)
+
+	// a ready container is not started again
+	require.NoError(t, q.Warm())
+	assert.Equal(t, uint64(1), d.RunAfterCounter())
 }
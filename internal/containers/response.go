@@ -0,0 +1,52 @@
+package containers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const (
+	// maxResponseSize is a maximum size of a calculation response body.
+	maxResponseSize = 1 << 20
+	// maxErrorBodySize is a maximum size of a response body quoted in errors.
+	maxErrorBodySize = 256
+)
+
+// readResult reads and closes the body of a calculation response and returns the result.
+// Statuses 400 and 422 mean the container rejected the input, any other non-2xx status
+// including 408 and 429 is a failure of the container that may pass on a retry.
+func (spec ImageSpec) readResult(resp *http.Response) (int, error) {
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize+1))
+	if err != nil {
+		return 0, withKind(ErrBadUpstreamResponse, fmt.Errorf("cannot read body: %w", err))
+	}
+	if len(body) > maxResponseSize {
+		return 0, withKind(ErrBadUpstreamResponse, fmt.Errorf("response body is larger than %d bytes", maxResponseSize))
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnprocessableEntity:
+		return 0, withKind(ErrBadInput, fmt.Errorf("input rejected with status %d: %q", resp.StatusCode, truncate(body)))
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return 0, withKind(ErrUpstreamFailure, fmt.Errorf("unexpected status %d: %q", resp.StatusCode, truncate(body)))
+	}
+
+	result, err := spec.parseResult(body)
+	if err != nil {
+		return 0, withKind(ErrBadUpstreamResponse, err)
+	}
+
+	return result, nil
+}
+
+// truncate shortens a body for an error message.
+func truncate(body []byte) string {
+	if len(body) > maxErrorBodySize {
+		return string(body[:maxErrorBodySize]) + "..."
+	}
+
+	return string(body)
+}
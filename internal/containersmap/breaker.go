@@ -0,0 +1,211 @@
+package containersmap
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Snyssfx/container_scheduler/internal/containers"
+	"github.com/Snyssfx/container_scheduler/internal/metrics"
+	"github.com/Snyssfx/container_scheduler/internal/snapshot"
+	"go.uber.org/zap"
+)
+
+// ErrCircuitOpen is returned for requests of a seed whose containers failed too many times in a row.
+var ErrCircuitOpen = errors.New("circuit is open")
+
+// CircuitOpenError is returned without calling containers of a seed with an open breaker.
+type CircuitOpenError struct {
+	Key Key
+	// RetryAfter is a time left until the breaker lets a probe request through.
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s for seed %d of %s, retry after %s", ErrCircuitOpen, e.Key.Seed, e.Key.Image, e.RetryAfter)
+}
+
+// Is matches ErrCircuitOpen.
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// Breaker is a circuit breaker of a seed for its deduplicator. It opens after failed
+// calculations in a row, fails requests fast during the cool-down and then lets one probe
+// request through: an outcome of the probe closes the breaker or opens it again.
+//
+// The deduplicator consults it on a cache miss and reports an outcome of every calculation
+// once, so cached results are served and deduplicated requests count as one failure.
+type Breaker struct {
+	l   *zap.SugaredLogger
+	key Key
+
+	mu sync.Mutex
+	// maxFailures is a count of failures in a row that opens the breaker, 0 disables it.
+	maxFailures int
+	coolDown    time.Duration
+	failures    int
+	lastFailure time.Time
+	// openedAt is zero while the breaker is closed.
+	openedAt time.Time
+	// probe is a number of the probe request in flight, 0 if there is none.
+	probe uint64
+	// probes counts probe requests, so an outcome of a finished probe is not taken for the current one.
+	probes uint64
+}
+
+// Breaker returns the breaker of the image and the seed. Its state survives evictions.
+func (c *ContainersMap) Breaker(key Key) *Breaker {
+	c.breakersMu.Lock()
+	defer c.breakersMu.Unlock()
+
+	b, ok := c.breakers[key]
+	if !ok {
+		b = &Breaker{l: c.l, key: key, maxFailures: c.breakerFailures, coolDown: c.breakerCoolDown}
+		c.breakers[key] = b
+	}
+
+	return b
+}
+
+// breaker returns the breaker of the key if a deduplicator of the key was ever created.
+func (c *ContainersMap) breaker(key Key) (*Breaker, bool) {
+	c.breakersMu.Lock()
+	defer c.breakersMu.Unlock()
+
+	b, ok := c.breakers[key]
+	return b, ok
+}
+
+// Allow checks the breaker before a calculation is requested. A probe request of a half-open
+// breaker is allowed once and gets a non-zero number, EndProbe has to be called with it
+// when the request is finished.
+func (b *Breaker) Allow() (probe uint64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.maxFailures == 0 || b.openedAt.IsZero() {
+		return 0, nil
+	}
+
+	if left := b.openedAt.Add(b.coolDown).Sub(time.Now()); left > 0 {
+		return 0, &CircuitOpenError{Key: b.key, RetryAfter: left}
+	}
+	if b.probe != 0 {
+		return 0, &CircuitOpenError{Key: b.key}
+	}
+
+	b.probes++
+	b.probe = b.probes
+	b.l.Infof("circuit of seed %d of %s is half-open, a probe request is sent", b.key.Seed, b.key.Image)
+	return b.probe, nil
+}
+
+// Report records the outcome of a calculation or a warm up by containers of the seed with
+// the number of the probe request that started it, 0 if other requests started it.
+// Only failures of containers count, errors like cancels or rejected inputs do not.
+// While the breaker is open only the outcome of the current probe closes or reopens it,
+// calculations that were in flight before the breaker opened do not.
+func (b *Breaker) Report(probe uint64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.openedAt.IsZero() && (probe == 0 || probe != b.probe) {
+		return
+	}
+
+	switch {
+	case err == nil:
+		if !b.openedAt.IsZero() {
+			b.l.Infof("circuit of seed %d of %s is closed", b.key.Seed, b.key.Image)
+		}
+		b.reset()
+
+	case containers.Failed(err):
+		if b.maxFailures == 0 {
+			return
+		}
+		b.failures++
+		b.lastFailure = time.Now()
+
+		if !b.openedAt.IsZero() || b.failures >= b.maxFailures {
+			b.openedAt = b.lastFailure
+			b.probe = 0
+			metrics.CircuitOpens.Inc()
+			b.l.Warnf(
+				"circuit of seed %d of %s is open for %s after %d failures: %s",
+				b.key.Seed, b.key.Image, b.coolDown, b.failures, err.Error(),
+			)
+		}
+	}
+}
+
+// EndProbe lets the next request probe the containers if the probe request finished
+// without an outcome of a calculation, e.g. it was canceled.
+func (b *Breaker) EndProbe(probe uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.probe == probe {
+		b.probe = 0
+	}
+}
+
+// state returns the state of the circuit for a snapshot.
+func (b *Breaker) state(now time.Time) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case b.openedAt.IsZero():
+		return snapshot.CircuitClosed
+	case now.Sub(b.openedAt) < b.coolDown:
+		return snapshot.CircuitOpen
+	default:
+		return snapshot.CircuitHalfOpen
+	}
+}
+
+// openError returns CircuitOpenError during the cool-down of the breaker.
+func (b *Breaker) openError(now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.maxFailures == 0 || b.openedAt.IsZero() {
+		return nil
+	}
+	if left := b.openedAt.Add(b.coolDown).Sub(now); left > 0 {
+		return &CircuitOpenError{Key: b.key, RetryAfter: left}
+	}
+
+	return nil
+}
+
+// forgotten tells if the breaker of a removed seed can be dropped: it is closed without
+// failures or its last failure is older than gcAfter.
+func (b *Breaker) forgotten(now time.Time, gcAfter time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return (b.openedAt.IsZero() && b.failures == 0) || (gcAfter > 0 && now.Sub(b.lastFailure) > gcAfter)
+}
+
+// setConfig changes limits of the breaker, a disabled breaker is closed.
+func (b *Breaker) setConfig(maxFailures int, coolDown time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.maxFailures = maxFailures
+	b.coolDown = coolDown
+	if maxFailures == 0 {
+		b.reset()
+	}
+}
+
+// reset closes the breaker. It is called under the mutex.
+func (b *Breaker) reset() {
+	b.failures = 0
+	b.openedAt = time.Time{}
+	b.probe = 0
+}
@@ -0,0 +1,112 @@
+package containersmap
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Snyssfx/container_scheduler/internal/containers"
+	"github.com/Snyssfx/container_scheduler/internal/snapshot"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestBreaker(t *testing.T) {
+	failure := fmt.Errorf("cannot start a container: %w", containers.ErrStart)
+	c := New(zap.NewNop().Sugar(), nil, Config{BreakerFailures: 2, BreakerCoolDown: time.Hour})
+	key := Key{Image: "qual-2021", Seed: 1}
+	b := c.Breaker(key)
+	require.Same(t, b, c.Breaker(key))
+	allow := func() error {
+		probe, err := b.Allow()
+		assert.Zero(t, probe)
+		return err
+	}
+	circuit := func() string {
+		return b.state(time.Now())
+	}
+
+	// rejected inputs are not failures of containers
+	b.Report(0, failure)
+	b.Report(0, fmt.Errorf("cannot calculate input 1: %w", containers.ErrBadInput))
+	require.NoError(t, allow())
+	b.Report(0, failure)
+	assert.Equal(t, snapshot.CircuitOpen, circuit())
+
+	err := allow()
+	var errOpen *CircuitOpenError
+	require.ErrorAs(t, err, &errOpen)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Greater(t, errOpen.RetryAfter, 59*time.Minute)
+	assert.ErrorIs(t, c.Warm(context.Background(), key.Image, key.Seed), ErrCircuitOpen)
+
+	// after the cool-down one probe is allowed, a failed calculation opens the breaker again
+	coolDown := func() {
+		b.mu.Lock()
+		b.openedAt = time.Now().Add(-2 * time.Hour)
+		b.mu.Unlock()
+	}
+	coolDown()
+	assert.Equal(t, snapshot.CircuitHalfOpen, circuit())
+	probe, err := b.Allow()
+	require.NoError(t, err)
+	assert.NotZero(t, probe)
+	assert.ErrorIs(t, allow(), ErrCircuitOpen, "other requests fail fast while the probe is in flight")
+	b.Report(probe, failure)
+	b.EndProbe(probe)
+	assert.Equal(t, snapshot.CircuitOpen, circuit())
+
+	// a probe finished without a calculation lets the next request probe
+	coolDown()
+	probe, err = b.Allow()
+	require.NoError(t, err)
+	assert.NotZero(t, probe)
+	b.EndProbe(probe)
+	next, err := b.Allow()
+	require.NoError(t, err)
+	assert.NotEqual(t, probe, next)
+
+	// outcomes of calculations started before the probe and of the finished probe do not count
+	b.Report(0, nil)
+	b.Report(probe, nil)
+	b.Report(0, failure)
+	b.Report(probe, failure)
+	assert.Equal(t, snapshot.CircuitHalfOpen, circuit())
+
+	// a successful calculation of the probe closes the breaker
+	b.Report(next, nil)
+	b.EndProbe(next)
+	assert.Equal(t, snapshot.CircuitClosed, circuit())
+	require.NoError(t, allow())
+}
+
+func TestBreaker_Forgotten(t *testing.T) {
+	c := New(zap.NewNop().Sugar(), nil, Config{BreakerFailures: 1, BreakerCoolDown: time.Hour, GCAfter: time.Hour})
+	c.Breaker(Key{Image: "qual-2021", Seed: 1})
+	open := c.Breaker(Key{Image: "qual-2021", Seed: 2})
+	open.Report(0, fmt.Errorf("cannot calculate input 1: %w", containers.ErrUnreachable))
+
+	// breakers of seeds without deduplicators are removed, open ones after the gc period
+	c.collect()
+	assert.Equal(t, map[Key]*Breaker{open.key: open}, c.breakers)
+
+	open.mu.Lock()
+	open.lastFailure = time.Now().Add(-2 * time.Hour)
+	open.mu.Unlock()
+	c.collect()
+	assert.Empty(t, c.breakers)
+}
+
+func TestBreaker_Disabled(t *testing.T) {
+	c := New(zap.NewNop().Sugar(), nil, Config{})
+	b := c.Breaker(Key{Image: "qual-2021", Seed: 1})
+
+	for i := 0; i < 10; i++ {
+		b.Report(0, fmt.Errorf("cannot calculate input 1: %w", containers.ErrUnreachable))
+		_, err := b.Allow()
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, snapshot.CircuitClosed, b.state(time.Now()))
+}
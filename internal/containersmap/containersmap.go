@@ -4,51 +4,170 @@ package containersmap
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/Snyssfx/container_scheduler/internal/containers"
+	"github.com/Snyssfx/container_scheduler/internal/metrics"
+	"github.com/Snyssfx/container_scheduler/internal/snapshot"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 )
 
-// ContainersMap is a map of seeds to containers.
+// gcInterval is a period of removing idle seeds.
+const gcInterval = time.Minute
+
+var (
+	// ErrNotPinned is returned on unpinning a seed that is not pinned.
+	ErrNotPinned = errors.New("seed is not pinned")
+	// ErrNotFound is returned for a seed without a deduplicator.
+	ErrNotFound = errors.New("seed not found")
+	// ErrCapacity is returned when there is no free container for a new seed.
+	ErrCapacity = errors.New("no free container")
+)
+
+// ContainersMap is a map of images and seeds to containers.
 // Containers are called deduplicators because they hold the logic to
 // deduplicate several user requests into one calculation.
 type ContainersMap struct {
 	l                  *zap.SugaredLogger
 	deduplicatorFabric deduplicatorFabric
+	maxContainers      int
+	maxWait            time.Duration
+	gcAfter            time.Duration
+
+	mu                sync.Mutex
+	keyToDeduplicator map[Key]*seedDeduplicator
+	// stopping is a count of evicted deduplicators that are still closing.
+	stopping int
+	// freed is closed and replaced when a deduplicator becomes idle or is removed.
+	freed chan struct{}
+	// idleTimeouts are idle timeouts of seeds set by SetIdleTimeout, they survive evictions.
+	idleTimeouts map[Key]time.Duration
 
-	mu                 sync.Mutex
-	seedToDeduplicator map[int]RequestDeduplicator
-	// TODO: add limit of maximum containers count
+	// breakersMu guards breakers and their limits apart from mu, so deduplicators
+	// get breakers while the map creates them under mu.
+	breakersMu      sync.Mutex
+	breakerFailures int
+	breakerCoolDown time.Duration
+	// breakers of seeds, they survive evictions.
+	breakers map[Key]*Breaker
 }
 
-type deduplicatorFabric func(l *zap.SugaredLogger, seed int) (RequestDeduplicator, error)
+// Config holds limits of a ContainersMap.
+type Config struct {
+	// MaxContainers is a maximum count of images and seeds with a container, 0 means no limit.
+	MaxContainers int
+	// MaxWait is a maximum time for a request of a new key to wait for a free slot,
+	// 0 means that only the request context limits it.
+	MaxWait time.Duration
+	// GCAfter is a time after the last request when a seed with stopped containers
+	// is removed, 0 means that seeds are removed only to make room for new ones.
+	GCAfter time.Duration
+	// BreakerFailures is a count of failed calculations of a seed in a row after which its
+	// requests fail fast for BreakerCoolDown, 0 disables breakers.
+	BreakerFailures int
+	// BreakerCoolDown is a time before a probe request of a seed with an open breaker.
+	BreakerCoolDown time.Duration
+}
+
+// Validate checks that the config is consistent.
+func (cfg Config) Validate() error {
+	if cfg.MaxContainers < 0 {
+		return fmt.Errorf("negative max containers: %d", cfg.MaxContainers)
+	}
+	if cfg.MaxWait < 0 {
+		return fmt.Errorf("negative max wait: %s", cfg.MaxWait)
+	}
+	if cfg.GCAfter < 0 {
+		return fmt.Errorf("negative gc after: %s", cfg.GCAfter)
+	}
+	if cfg.BreakerFailures < 0 || (cfg.BreakerFailures > 0 && cfg.BreakerCoolDown <= 0) {
+		return fmt.Errorf(
+			"breaker failures %d should not be negative and need positive cool-down, got %s",
+			cfg.BreakerFailures, cfg.BreakerCoolDown,
+		)
+	}
+
+	return nil
+}
+
+// Key identifies a deduplicator: an image and a seed.
+type Key struct {
+	Image string
+	Seed  int
+}
+
+type deduplicatorFabric func(l *zap.SugaredLogger, key Key) (RequestDeduplicator, error)
 
 type RequestDeduplicator interface {
 	Calculate(ctx context.Context, input int) (int, error)
 	Close() error
+	SetConfig(cfg containers.Config) error
+	SetIdleTimeout(idle time.Duration)
+	SetPinned(pinned bool)
+	Status() snapshot.Deduplicator
+	Warm() error
+}
+
+// seedDeduplicator is a deduplicator with its usage.
+type seedDeduplicator struct {
+	d        RequestDeduplicator
+	inFlight int
+	lastUsed time.Time
+	// pinned deduplicators are never evicted.
+	pinned bool
 }
 
 // New creates new ContainersMap
-func New(logger *zap.SugaredLogger, deduplicatorFabric deduplicatorFabric) *ContainersMap {
+func New(logger *zap.SugaredLogger, deduplicatorFabric deduplicatorFabric, cfg Config) *ContainersMap {
 	return &ContainersMap{
 		l:                  logger,
-		mu:                 sync.Mutex{},
 		deduplicatorFabric: deduplicatorFabric,
-		seedToDeduplicator: make(map[int]RequestDeduplicator),
+		maxContainers:      cfg.MaxContainers,
+		maxWait:            cfg.MaxWait,
+		gcAfter:            cfg.GCAfter,
+
+		mu:                sync.Mutex{},
+		keyToDeduplicator: make(map[Key]*seedDeduplicator),
+		freed:             make(chan struct{}),
+		idleTimeouts:      make(map[Key]time.Duration),
+
+		breakerFailures: cfg.BreakerFailures,
+		breakerCoolDown: cfg.BreakerCoolDown,
+		breakers:        make(map[Key]*Breaker),
 	}
 }
 
-// Close closes all deduplicators.
+// Close closes all deduplicators in parallel, an error of one does not stop the others.
 func (c *ContainersMap) Close() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	for _, d := range c.seedToDeduplicator {
-		err := d.Close()
-		if err != nil {
-			return fmt.Errorf("cannot close deduplicator: %w", err)
-		}
+	errs := make([]error, len(c.keyToDeduplicator))
+	wg := sync.WaitGroup{}
+	i := 0
+	for key, sd := range c.keyToDeduplicator {
+		wg.Add(1)
+		go func(i int, key Key, sd *seedDeduplicator) {
+			defer wg.Done()
+
+			err := sd.d.Close()
+			if err != nil {
+				errs[i] = fmt.Errorf("cannot close deduplicator %d of %s: %w", key.Seed, key.Image, err)
+			}
+		}(i, key, sd)
+
+		i++
+	}
+	wg.Wait()
+
+	err := multierr.Combine(errs...)
+	if err != nil {
+		return err
 	}
 
 	c.l.Infof("container map closed")
@@ -56,30 +175,461 @@ func (c *ContainersMap) Close() error {
 }
 
 // Calculate gets existing or creates new deduplicator and he calculates a result.
-func (c *ContainersMap) Calculate(ctx context.Context, seed, input int) (int, error) {
-	d, err := c.getOrCreateDeduplicator(seed)
+// Requests of a seed with an open breaker that miss the cache fail fast with CircuitOpenError.
+func (c *ContainersMap) Calculate(ctx context.Context, image string, seed, input int) (int, error) {
+	key := Key{Image: image, Seed: seed}
+
+	d, err := c.acquire(ctx, key)
 	if err != nil {
 		return 0, err
 	}
+	defer c.release(key, d)
 
 	return d.Calculate(ctx, input)
 }
 
-func (c *ContainersMap) getOrCreateDeduplicator(seed int) (RequestDeduplicator, error) {
+// Adopt adds a deduplicator created outside of the map, e.g. of containers of a previous run.
+func (c *ContainersMap) Adopt(key Key, d RequestDeduplicator) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.keyToDeduplicator[key]; ok {
+		return fmt.Errorf("deduplicator %d of %s already exists", key.Seed, key.Image)
+	}
+
+	if c.maxContainers > 0 && len(c.keyToDeduplicator)+c.stopping >= c.maxContainers {
+		return fmt.Errorf("%w: max containers %d reached", ErrCapacity, c.maxContainers)
+	}
+
+	c.keyToDeduplicator[key] = &seedDeduplicator{d: d, lastUsed: time.Now()}
+	if idle, ok := c.idleTimeouts[key]; ok {
+		d.SetIdleTimeout(idle)
+	}
+	c.l.Infof("container %d of %s adopted", key.Seed, key.Image)
+
+	return nil
+}
+
+// Warm creates the deduplicator of the image and the seed if needed and starts its
+// containers in background. The deduplicator is not evicted until they are started.
+func (c *ContainersMap) Warm(ctx context.Context, image string, seed int) error {
+	return c.warm(ctx, Key{Image: image, Seed: seed}, false)
+}
+
+// Pin warms the image and the seed and keeps them: the deduplicator is not evicted
+// and its containers are not stopped after the idle timeout until Unpin.
+func (c *ContainersMap) Pin(ctx context.Context, image string, seed int) error {
+	return c.warm(ctx, Key{Image: image, Seed: seed}, true)
+}
+
+// Unpin returns the pinned image and seed to the usual idle timeout and eviction.
+func (c *ContainersMap) Unpin(image string, seed int) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	var err error
-	d, ok := c.seedToDeduplicator[seed]
+	sd, ok := c.keyToDeduplicator[Key{Image: image, Seed: seed}]
+	if !ok || !sd.pinned {
+		return ErrNotPinned
+	}
+
+	sd.pinned = false
+	sd.d.SetPinned(false)
+	c.broadcastFreed()
+
+	c.l.Infof("container %d of %s unpinned", seed, image)
+	return nil
+}
+
+// SetIdleTimeout overrides the idle timeout of containers of the image and the seed,
+// including ones created later. 0 returns the adaptive idle timeout or the one of the config.
+func (c *ContainersMap) SetIdleTimeout(image string, seed int, idle time.Duration) {
+	key := Key{Image: image, Seed: seed}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if idle > 0 {
+		c.idleTimeouts[key] = idle
+	} else {
+		delete(c.idleTimeouts, key)
+	}
+
+	if sd, ok := c.keyToDeduplicator[key]; ok {
+		sd.d.SetIdleTimeout(idle)
+	}
+
+	c.l.Infof("idle timeout of container %d of %s set to %s", seed, image, idle)
+}
+
+// Status returns states of all seeds ordered by images and seeds.
+func (c *ContainersMap) Status() []snapshot.Seed {
+	c.mu.Lock()
+	now := time.Now()
+	seeds := make([]snapshot.Seed, 0, len(c.keyToDeduplicator))
+	ds := make([]RequestDeduplicator, 0, len(c.keyToDeduplicator))
+	for key, sd := range c.keyToDeduplicator {
+		circuit := snapshot.CircuitClosed
+		if b, ok := c.breaker(key); ok {
+			circuit = b.state(now)
+		}
+		seeds = append(seeds, snapshot.Seed{
+			Image:    key.Image,
+			Seed:     key.Seed,
+			Pinned:   sd.pinned,
+			Requests: sd.inFlight,
+			LastUsed: sd.lastUsed,
+			Circuit:  circuit,
+		})
+		ds = append(ds, sd.d)
+	}
+	c.mu.Unlock()
+
+	// deduplicators are asked without the mutex, so a busy one does not block the map
+	for i, d := range ds {
+		seeds[i].Deduplicator = d.Status()
+	}
+
+	sort.Slice(seeds, func(i, j int) bool {
+		if seeds[i].Image != seeds[j].Image {
+			return seeds[i].Image < seeds[j].Image
+		}
+		return seeds[i].Seed < seeds[j].Seed
+	})
+
+	return seeds
+}
+
+// Evict stops containers of the image and the seed and removes its deduplicator even if
+// it is pinned or busy, requests in flight fail. A new request creates a new deduplicator.
+func (c *ContainersMap) Evict(image string, seed int) error {
+	key := Key{Image: image, Seed: seed}
+
+	c.mu.Lock()
+	sd, ok := c.keyToDeduplicator[key]
 	if !ok {
-		d, err = c.deduplicatorFabric(c.l, seed)
+		c.mu.Unlock()
+		return ErrNotFound
+	}
+
+	delete(c.keyToDeduplicator, key)
+	c.stopping++
+	c.mu.Unlock()
+
+	c.evict(key, sd)
+	return nil
+}
+
+func (c *ContainersMap) warm(ctx context.Context, key Key, pin bool) error {
+	// warming does not probe a seed with an open breaker, it starts containers only after the cool-down
+	if b, ok := c.breaker(key); ok {
+		if err := b.openError(time.Now()); err != nil {
+			return err
+		}
+	}
+
+	d, err := c.acquire(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	if pin {
+		// the seed may be evicted and created again after acquire
+		c.mu.Lock()
+		sd, ok := c.keyToDeduplicator[key]
+		if !ok || sd.d != d {
+			c.mu.Unlock()
+			c.release(key, d)
+			return fmt.Errorf("%w: seed %d of %s was evicted while pinning", ErrNotFound, key.Seed, key.Image)
+		}
+		sd.pinned = true
+		c.mu.Unlock()
+		d.SetPinned(true)
+		c.l.Infof("container %d of %s pinned", key.Seed, key.Image)
+	}
+
+	go func() {
+		defer c.release(key, d)
+
+		err := d.Warm()
+		if err != nil {
+			c.l.Errorf("cannot warm container %d of %s: %s", key.Seed, key.Image, err.Error())
+			return
+		}
+		c.l.Infof("container %d of %s warmed", key.Seed, key.Image)
+	}()
+
+	return nil
+}
+
+// SetConfig changes limits of the running ContainersMap. If the count of containers is
+// over the new limit, idle ones are evicted, busy ones are evicted when they become idle
+// and a new key needs room.
+func (c *ContainersMap) SetConfig(cfg Config) error {
+	err := cfg.Validate()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxContainers = cfg.MaxContainers
+	c.maxWait = cfg.MaxWait
+	c.gcAfter = cfg.GCAfter
+	c.breakersMu.Lock()
+	c.breakerFailures = cfg.BreakerFailures
+	c.breakerCoolDown = cfg.BreakerCoolDown
+	for _, b := range c.breakers {
+		b.setConfig(cfg.BreakerFailures, cfg.BreakerCoolDown)
+	}
+	c.breakersMu.Unlock()
+
+	for c.maxContainers > 0 && len(c.keyToDeduplicator) > c.maxContainers {
+		victimKey, victim := c.leastRecentlyUsedIdle()
+		if victim == nil {
+			break
+		}
+
+		delete(c.keyToDeduplicator, victimKey)
+		c.stopping++
+		go c.evict(victimKey, victim)
+	}
+
+	// waiters recheck the limit
+	c.broadcastFreed()
+	c.l.Infof(
+		"containers map limits changed: max containers %d, max wait %s, gc after %s, breaker failures %d, breaker cool-down %s",
+		cfg.MaxContainers, cfg.MaxWait, cfg.GCAfter, cfg.BreakerFailures, cfg.BreakerCoolDown,
+	)
+
+	return nil
+}
+
+// SetContainersConfig applies the config to containers of all deduplicators.
+func (c *ContainersMap) SetContainersConfig(cfg containers.Config) error {
+	err := cfg.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid containers config: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, sd := range c.keyToDeduplicator {
+		err = sd.d.SetConfig(cfg)
 		if err != nil {
-			return nil, fmt.Errorf("cannot create cached deduplicator: %w", err)
+			return fmt.Errorf("cannot set config of deduplicator %d of %s: %w", key.Seed, key.Image, err)
 		}
+	}
+
+	return nil
+}
+
+// acquire returns a deduplicator for the key and marks it busy. If there is no room
+// for a new key, it evicts the least recently used idle key or waits for one.
+func (c *ContainersMap) acquire(ctx context.Context, key Key) (RequestDeduplicator, error) {
+	c.mu.Lock()
+	maxWait := c.maxWait
+	c.mu.Unlock()
+
+	parent := ctx
+	if maxWait > 0 {
+		var cancelFn context.CancelFunc
+		ctx, cancelFn = context.WithTimeout(ctx, maxWait)
+		defer cancelFn()
+	}
+
+	for {
+		c.mu.Lock()
+
+		sd, err := c.getOrCreateDeduplicator(key)
+		if err != nil {
+			c.mu.Unlock()
+			return nil, err
+		}
+
+		if sd != nil {
+			sd.inFlight++
+			sd.lastUsed = time.Now()
+			c.mu.Unlock()
+			return sd.d, nil
+		}
+
+		victimKey, victim := c.leastRecentlyUsedIdle()
+		if victim != nil {
+			delete(c.keyToDeduplicator, victimKey)
+			c.stopping++
+			c.mu.Unlock()
+
+			c.evict(victimKey, victim)
+			continue
+		}
+
+		freed := c.freed
+		c.mu.Unlock()
+
+		c.l.Debugf("seed %d of %s waits for a free container", key.Seed, key.Image)
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			if parent.Err() != nil {
+				return nil, fmt.Errorf("cannot wait for a free container for seed %d of %s: %w", key.Seed, key.Image, parent.Err())
+			}
+			return nil, fmt.Errorf("%w for seed %d of %s in %s", ErrCapacity, key.Seed, key.Image, maxWait)
+		}
+	}
+}
+
+// release marks one request of the key finished. The deduplicator may be already evicted
+// and replaced with a new one.
+func (c *ContainersMap) release(key Key, d RequestDeduplicator) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sd, ok := c.keyToDeduplicator[key]
+	if !ok || sd.d != d {
+		return
+	}
+
+	sd.inFlight--
+	if sd.inFlight == 0 {
+		c.broadcastFreed()
+	}
+}
 
-		c.seedToDeduplicator[seed] = d
-		c.l.Infof("container %d created", seed)
+// getOrCreateDeduplicator returns nil without an error if the key is new
+// and there is no room for it. It is called under the mutex.
+func (c *ContainersMap) getOrCreateDeduplicator(key Key) (*seedDeduplicator, error) {
+	sd, ok := c.keyToDeduplicator[key]
+	if ok {
+		return sd, nil
 	}
 
-	return d, nil
+	if c.maxContainers > 0 && len(c.keyToDeduplicator)+c.stopping >= c.maxContainers {
+		return nil, nil
+	}
+
+	d, err := c.deduplicatorFabric(c.l, key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create cached deduplicator: %w", err)
+	}
+
+	if idle, ok := c.idleTimeouts[key]; ok {
+		d.SetIdleTimeout(idle)
+	}
+
+	sd = &seedDeduplicator{d: d}
+	c.keyToDeduplicator[key] = sd
+	c.l.Infof("container %d of %s created", key.Seed, key.Image)
+
+	return sd, nil
+}
+
+// leastRecentlyUsedIdle returns an idle not pinned deduplicator that was used the earliest.
+// It is called under the mutex.
+func (c *ContainersMap) leastRecentlyUsedIdle() (Key, *seedDeduplicator) {
+	var (
+		victimKey Key
+		victim    *seedDeduplicator
+	)
+
+	for key, sd := range c.keyToDeduplicator {
+		if sd.inFlight > 0 || sd.pinned {
+			continue
+		}
+
+		if victim == nil || sd.lastUsed.Before(victim.lastUsed) {
+			victimKey, victim = key, sd
+		}
+	}
+
+	return victimKey, victim
+}
+
+// Run removes idle seeds every gcInterval until the context is done.
+func (c *ContainersMap) Run(ctx context.Context) {
+	ticker := time.NewTicker(gcInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.collect()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// collect removes seeds that are not pinned, have no requests for the gc period and
+// have no running containers. Their results stay in the shared cache.
+func (c *ContainersMap) collect() {
+	c.mu.Lock()
+	gcAfter := c.gcAfter
+	// breakers of removed seeds are forgotten like the seeds
+	c.breakersMu.Lock()
+	for key, b := range c.breakers {
+		if _, ok := c.keyToDeduplicator[key]; !ok && b.forgotten(time.Now(), gcAfter) {
+			delete(c.breakers, key)
+		}
+	}
+	c.breakersMu.Unlock()
+	candidates := make(map[Key]*seedDeduplicator)
+	for key, sd := range c.keyToDeduplicator {
+		if gcAfter > 0 && sd.inFlight == 0 && !sd.pinned && time.Since(sd.lastUsed) > gcAfter {
+			candidates[key] = sd
+		}
+	}
+	c.mu.Unlock()
+
+	for key, sd := range candidates {
+		// deduplicators are asked without the mutex, so a busy one does not block the map
+		if running(sd.d.Status()) {
+			continue
+		}
+
+		c.mu.Lock()
+		// the seed may be used while its containers are checked
+		if c.keyToDeduplicator[key] != sd || sd.inFlight > 0 || time.Since(sd.lastUsed) <= gcAfter {
+			c.mu.Unlock()
+			continue
+		}
+		delete(c.keyToDeduplicator, key)
+		c.stopping++
+		c.mu.Unlock()
+
+		c.evict(key, sd)
+		metrics.SeedsCollected.Inc()
+	}
+}
+
+func running(s snapshot.Deduplicator) bool {
+	for _, c := range s.Containers {
+		if c.State == snapshot.StateReady {
+			return true
+		}
+	}
+
+	return false
+}
+
+// evict closes the removed deduplicator and frees its slot.
+func (c *ContainersMap) evict(key Key, sd *seedDeduplicator) {
+	err := sd.d.Close()
+	if err != nil {
+		c.l.Errorf("cannot close evicted deduplicator %d of %s: %s", key.Seed, key.Image, err.Error())
+	}
+
+	c.mu.Lock()
+	c.stopping--
+	c.broadcastFreed()
+	c.mu.Unlock()
+
+	c.l.Infof("container %d of %s evicted", key.Seed, key.Image)
+}
+
+// broadcastFreed wakes up all waiting requests. It is called under the mutex.
+func (c *ContainersMap) broadcastFreed() {
+	close(c.freed)
+	c.freed = make(chan struct{})
 }
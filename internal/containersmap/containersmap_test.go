@@ -2,30 +2,401 @@ package containersmap
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/Snyssfx/container_scheduler/internal/containers"
 	"github.com/Snyssfx/container_scheduler/internal/containersmap/mock"
+	"github.com/Snyssfx/container_scheduler/internal/snapshot"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 )
 
 func TestContainersMap_Calculate(t *testing.T) {
-	c := New(zap.NewNop().Sugar(), func(l *zap.SugaredLogger, seed int) (RequestDeduplicator, error) {
+	c := New(zap.NewNop().Sugar(), func(l *zap.SugaredLogger, key Key) (RequestDeduplicator, error) {
 		rd := mock.NewRequestDeduplicatorMock(t)
 		rd.CalculateMock.Set(func(ctx context.Context, input int) (i1 int, err error) {
 			assert.Equal(t, input, 1)
 			return 2, nil
 		})
 		return rd, nil
-	})
+	}, Config{})
 
-	_, err := c.Calculate(context.Background(), 1, 1)
+	_, err := c.Calculate(context.Background(), "qual-2021", 1, 1)
 	require.NoError(t, err)
-	_, err = c.Calculate(context.Background(), 2, 1)
+	_, err = c.Calculate(context.Background(), "qual-2021", 2, 1)
 	require.NoError(t, err)
-	_, err = c.Calculate(context.Background(), 1, 1)
+	_, err = c.Calculate(context.Background(), "qual-2021", 1, 1)
 	require.NoError(t, err)
+	_, err = c.Calculate(context.Background(), "other", 1, 1)
+	require.NoError(t, err)
+
+	assert.Len(t, c.keyToDeduplicator, 3)
+}
+
+func TestContainersMap_Calculate_EvictsLeastRecentlyUsed(t *testing.T) {
+	closed := make(map[int]bool)
+	c := New(zap.NewNop().Sugar(), func(l *zap.SugaredLogger, key Key) (RequestDeduplicator, error) {
+		seed := key.Seed
+		rd := mock.NewRequestDeduplicatorMock(t)
+		rd.CalculateMock.Return(seed, nil)
+		rd.CloseMock.Set(func() error {
+			closed[seed] = true
+			return nil
+		})
+		return rd, nil
+	}, Config{MaxContainers: 2})
+
+	for _, seed := range []int{1, 2, 1, 3} {
+		got, err := c.Calculate(context.Background(), "qual-2021", seed, 1)
+		require.NoError(t, err)
+		assert.Equal(t, seed, got)
+	}
+
+	assert.Equal(t, map[int]bool{2: true}, closed)
+	assert.Len(t, c.keyToDeduplicator, 2)
+	assert.Contains(t, c.keyToDeduplicator, Key{Image: "qual-2021", Seed: 1})
+	assert.Contains(t, c.keyToDeduplicator, Key{Image: "qual-2021", Seed: 3})
+}
+
+func TestContainersMap_Calculate_WaitsForIdle(t *testing.T) {
+	release := make(chan struct{})
+	c := New(zap.NewNop().Sugar(), func(l *zap.SugaredLogger, key Key) (RequestDeduplicator, error) {
+		seed := key.Seed
+		rd := mock.NewRequestDeduplicatorMock(t)
+		rd.CalculateMock.Set(func(ctx context.Context, input int) (i1 int, err error) {
+			if seed == 1 {
+				<-release
+			}
+			return seed, nil
+		})
+		rd.CloseMock.Return(nil)
+		return rd, nil
+	}, Config{MaxContainers: 1, MaxWait: time.Second})
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err := c.Calculate(context.Background(), "qual-2021", 1, 1)
+		require.NoError(t, err)
+	}()
+
+	require.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.keyToDeduplicator) == 1
+	}, time.Second, time.Millisecond)
+
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+	got, err := c.Calculate(context.Background(), "qual-2021", 2, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, got)
+
+	wg.Wait()
+}
+
+func TestContainersMap_Calculate_WaitTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	c := New(zap.NewNop().Sugar(), func(l *zap.SugaredLogger, key Key) (RequestDeduplicator, error) {
+		seed := key.Seed
+		rd := mock.NewRequestDeduplicatorMock(t)
+		rd.CalculateMock.Set(func(ctx context.Context, input int) (i1 int, err error) {
+			<-release
+			return seed, nil
+		})
+		return rd, nil
+	}, Config{MaxContainers: 1, MaxWait: 50 * time.Millisecond})
+
+	go func() { _, _ = c.Calculate(context.Background(), "qual-2021", 1, 1) }()
+	require.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.keyToDeduplicator) == 1
+	}, time.Second, time.Millisecond)
+
+	_, err := c.Calculate(context.Background(), "qual-2021", 2, 1)
+	require.ErrorIs(t, err, ErrCapacity)
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	cancelFn()
+	_, err = c.Calculate(ctx, "qual-2021", 2, 1)
+	require.ErrorIs(t, err, context.Canceled)
+	require.NotErrorIs(t, err, ErrCapacity)
+}
+
+func TestContainersMap_SetConfig(t *testing.T) {
+	mu := sync.Mutex{}
+	closed := make(map[int]bool)
+	reconfigured := make(map[int]bool)
+	c := New(zap.NewNop().Sugar(), func(l *zap.SugaredLogger, key Key) (RequestDeduplicator, error) {
+		seed := key.Seed
+		rd := mock.NewRequestDeduplicatorMock(t)
+		rd.CalculateMock.Return(seed, nil)
+		rd.CloseMock.Set(func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			closed[seed] = true
+			return nil
+		})
+		rd.SetConfigMock.Set(func(cfg containers.Config) error {
+			reconfigured[seed] = true
+			return nil
+		})
+		return rd, nil
+	}, Config{})
+
+	for _, seed := range []int{1, 2, 3} {
+		_, err := c.Calculate(context.Background(), "qual-2021", seed, 1)
+		require.NoError(t, err)
+	}
+
+	require.Error(t, c.SetConfig(Config{MaxContainers: -1}))
+	require.NoError(t, c.SetConfig(Config{MaxContainers: 1}))
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(closed) == 2
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, map[int]bool{1: true, 2: true}, closed)
+
+	cfg := containers.Config{
+		Runtime:               containers.RuntimeCLI,
+		InitializationTimeout: time.Second,
+		CalculationTimeout:    time.Second,
+		IdleTimeout:           time.Second,
+		HealthInterval:        time.Second,
+	}
+	require.NoError(t, c.SetContainersConfig(cfg))
+	assert.Equal(t, map[int]bool{3: true}, reconfigured)
+
+	cfg.IdleTimeout = 0
+	require.Error(t, c.SetContainersConfig(cfg))
+}
+
+func TestContainersMap_Close(t *testing.T) {
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	c := New(zap.NewNop().Sugar(), func(l *zap.SugaredLogger, key Key) (RequestDeduplicator, error) {
+		seed := key.Seed
+		rd := mock.NewRequestDeduplicatorMock(t)
+		rd.CalculateMock.Return(seed, nil)
+		rd.CloseMock.Set(func() error {
+			started <- struct{}{}
+			<-release
+			if seed == 2 {
+				return errors.New("docker is down")
+			}
+			return nil
+		})
+		return rd, nil
+	}, Config{})
+
+	for _, seed := range []int{1, 2, 3} {
+		_, err := c.Calculate(context.Background(), "qual-2021", seed, 1)
+		require.NoError(t, err)
+	}
+
+	errCh := make(chan error)
+	go func() { errCh <- c.Close() }()
+
+	// all deduplicators are closing at the same time
+	for i := 0; i < 3; i++ {
+		<-started
+	}
+	close(release)
+
+	err := <-errCh
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "deduplicator 2 of qual-2021: docker is down")
+}
+
+func TestContainersMap_Adopt(t *testing.T) {
+	created := 0
+	c := New(zap.NewNop().Sugar(), func(l *zap.SugaredLogger, key Key) (RequestDeduplicator, error) {
+		created++
+		return mock.NewRequestDeduplicatorMock(t), nil
+	}, Config{MaxContainers: 1})
+
+	adopted := mock.NewRequestDeduplicatorMock(t)
+	adopted.CalculateMock.Return(2, nil)
+	key := Key{Image: "qual-2021", Seed: 1}
+
+	require.NoError(t, c.Adopt(key, adopted))
+	assert.Error(t, c.Adopt(key, mock.NewRequestDeduplicatorMock(t)))
+	assert.Error(t, c.Adopt(Key{Image: "qual-2021", Seed: 2}, mock.NewRequestDeduplicatorMock(t)))
+
+	got, err := c.Calculate(context.Background(), "qual-2021", 1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, got)
+	assert.Equal(t, 0, created)
+}
+
+func TestContainersMap_Pin(t *testing.T) {
+	warmed := make(chan Key, 2)
+	pinned := map[Key]bool{}
+	mu := sync.Mutex{}
+	c := New(zap.NewNop().Sugar(), func(l *zap.SugaredLogger, key Key) (RequestDeduplicator, error) {
+		rd := mock.NewRequestDeduplicatorMock(t)
+		rd.WarmMock.Set(func() error {
+			warmed <- key
+			return nil
+		})
+		rd.SetPinnedMock.Set(func(p bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			pinned[key] = p
+		})
+		rd.CalculateMock.Return(1, nil)
+		rd.CloseMock.Return(nil)
+		return rd, nil
+	}, Config{MaxContainers: 1, MaxWait: time.Second})
+
+	require.NoError(t, c.Pin(context.Background(), "qual-2021", 1))
+	assert.Equal(t, Key{Image: "qual-2021", Seed: 1}, <-warmed)
+	mu.Lock()
+	assert.True(t, pinned[Key{Image: "qual-2021", Seed: 1}])
+	mu.Unlock()
+
+	// the pinned seed is not evicted for a new one
+	ctx, cancelFn := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelFn()
+	_, err := c.Calculate(ctx, "qual-2021", 2, 1)
+	require.Error(t, err)
+
+	require.NoError(t, c.Unpin("qual-2021", 1))
+	assert.ErrorIs(t, c.Unpin("qual-2021", 1), ErrNotPinned)
+	mu.Lock()
+	assert.False(t, pinned[Key{Image: "qual-2021", Seed: 1}])
+	mu.Unlock()
+
+	require.NoError(t, c.Warm(context.Background(), "qual-2021", 2))
+	assert.Equal(t, Key{Image: "qual-2021", Seed: 2}, <-warmed)
+	assert.ErrorIs(t, c.Unpin("qual-2021", 2), ErrNotPinned)
+}
+
+func TestContainersMap_SetIdleTimeout(t *testing.T) {
+	idle := map[int][]time.Duration{}
+	c := New(zap.NewNop().Sugar(), func(l *zap.SugaredLogger, key Key) (RequestDeduplicator, error) {
+		rd := mock.NewRequestDeduplicatorMock(t)
+		rd.CalculateMock.Return(1, nil)
+		rd.SetIdleTimeoutMock.Set(func(d time.Duration) { idle[key.Seed] = append(idle[key.Seed], d) })
+		return rd, nil
+	}, Config{})
+
+	_, err := c.Calculate(context.Background(), "qual-2021", 1, 1)
+	require.NoError(t, err)
+	c.SetIdleTimeout("qual-2021", 1, time.Hour)
+	c.SetIdleTimeout("qual-2021", 2, time.Minute)
+	c.SetIdleTimeout("qual-2021", 1, 0)
+
+	// the override is applied to a deduplicator created later
+	_, err = c.Calculate(context.Background(), "qual-2021", 2, 1)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[int][]time.Duration{1: {time.Hour, 0}, 2: {time.Minute}}, idle)
+	assert.Equal(t, map[Key]time.Duration{{Image: "qual-2021", Seed: 2}: time.Minute}, c.idleTimeouts)
+}
+
+func TestContainersMap_Status(t *testing.T) {
+	c := New(zap.NewNop().Sugar(), func(l *zap.SugaredLogger, key Key) (RequestDeduplicator, error) {
+		rd := mock.NewRequestDeduplicatorMock(t)
+		rd.CalculateMock.Return(1, nil)
+		rd.StatusMock.Return(snapshot.Deduplicator{CachedResults: key.Seed})
+		return rd, nil
+	}, Config{})
+
+	for _, key := range []Key{{"qual-2021", 2}, {"other", 1}, {"qual-2021", 1}} {
+		_, err := c.Calculate(context.Background(), key.Image, key.Seed, 1)
+		require.NoError(t, err)
+	}
+
+	got := c.Status()
+
+	require.Len(t, got, 3)
+	for i, want := range []Key{{"other", 1}, {"qual-2021", 1}, {"qual-2021", 2}} {
+		assert.Equal(t, want, Key{Image: got[i].Image, Seed: got[i].Seed})
+		assert.Equal(t, want.Seed, got[i].CachedResults)
+		assert.Zero(t, got[i].Requests)
+		assert.False(t, got[i].LastUsed.IsZero())
+	}
+}
+
+func TestContainersMap_Evict(t *testing.T) {
+	created := 0
+	c := New(zap.NewNop().Sugar(), func(l *zap.SugaredLogger, key Key) (RequestDeduplicator, error) {
+		created++
+		rd := mock.NewRequestDeduplicatorMock(t)
+		rd.CalculateMock.Return(1, nil)
+		rd.CloseMock.Return(nil)
+		return rd, nil
+	}, Config{MaxContainers: 1})
+
+	assert.ErrorIs(t, c.Evict("qual-2021", 1), ErrNotFound)
+
+	key := Key{Image: "qual-2021", Seed: 1}
+	d, err := c.acquire(context.Background(), key)
+	require.NoError(t, err)
+	require.NoError(t, c.Evict("qual-2021", 1))
+	assert.Empty(t, c.keyToDeduplicator)
+	assert.Zero(t, c.stopping)
+
+	// a request of the evicted deduplicator does not release the new one
+	_, err = c.acquire(context.Background(), key)
+	require.NoError(t, err)
+	c.release(key, d)
+	assert.Equal(t, 1, c.keyToDeduplicator[key].inFlight)
+	assert.Equal(t, 2, created)
+}
+
+func TestContainersMap_collect(t *testing.T) {
+	closed := map[int]bool{}
+	mu := sync.Mutex{}
+	c := New(zap.NewNop().Sugar(), func(l *zap.SugaredLogger, key Key) (RequestDeduplicator, error) {
+		rd := mock.NewRequestDeduplicatorMock(t)
+		rd.CalculateMock.Return(1, nil)
+		state := snapshot.StateStopped
+		if key.Seed == 2 {
+			state = snapshot.StateReady
+		}
+		rd.StatusMock.Return(snapshot.Deduplicator{Containers: []snapshot.Container{{State: state}}})
+		rd.CloseMock.Set(func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			closed[key.Seed] = true
+			return nil
+		})
+		return rd, nil
+	}, Config{GCAfter: time.Minute})
+
+	for seed := 1; seed <= 4; seed++ {
+		_, err := c.Calculate(context.Background(), "qual-2021", seed, 1)
+		require.NoError(t, err)
+	}
+	c.keyToDeduplicator[Key{"qual-2021", 3}].pinned = true
+	for key, sd := range c.keyToDeduplicator {
+		if key.Seed != 4 {
+			sd.lastUsed = time.Now().Add(-2 * time.Minute)
+		}
+	}
+
+	c.collect()
+
+	// only the idle seed with stopped containers is removed
+	assert.Equal(t, map[int]bool{1: true}, closed)
+	assert.Len(t, c.keyToDeduplicator, 3)
+	assert.NotContains(t, c.keyToDeduplicator, Key{"qual-2021", 1})
+	assert.Zero(t, c.stopping)
 
-	assert.Len(t, c.seedToDeduplicator, 2)
+	require.NoError(t, c.SetConfig(Config{}))
+	for _, sd := range c.keyToDeduplicator {
+		sd.lastUsed = time.Now().Add(-time.Hour)
+	}
+	c.collect()
+	assert.Len(t, c.keyToDeduplicator, 3, "gc is disabled")
 }
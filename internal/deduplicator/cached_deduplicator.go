@@ -4,65 +4,153 @@ package deduplicator
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"time"
 
+	"github.com/Snyssfx/container_scheduler/internal/cache"
+	"github.com/Snyssfx/container_scheduler/internal/containers"
+	"github.com/Snyssfx/container_scheduler/internal/metrics"
+	"github.com/Snyssfx/container_scheduler/internal/snapshot"
 	"go.uber.org/zap"
 )
 
+// maxRejected is a maximum count of inputs rejected by containers that are remembered per seed.
+const maxRejected = 1024
+
 // CachedDeduplicator is a middleware between containersMap and RequestDeduplicator.
-// It caches all results from a RequestDeduplicator.
+// It caches results from a RequestDeduplicator in a bounded cache shared by all seeds,
+// and errors of inputs rejected by containers while the seed is in the map.
 type CachedDeduplicator struct {
-	l *zap.SugaredLogger
-	d requestDeduplicator
+	l     *zap.SugaredLogger
+	image string
+	seed  int
+	d     requestDeduplicator
+	cache ResultCache
+	// onMiss is called for every request that misses the cache, it may be nil.
+	onMiss func()
 
-	// TODO: add hard limits and eviction strategy for a cache.
-	mu            sync.RWMutex
-	inputToResult map[int]int
+	rejectedMu sync.Mutex
+	rejected   map[int]error
 }
 
 type requestDeduplicator interface {
 	Calculate(ctx context.Context, input int) (int, error)
 	Close() error
+	SetConfig(cfg containers.Config) error
+	SetIdleTimeout(idle time.Duration)
+	SetPinned(pinned bool)
+	Status() snapshot.Deduplicator
+	Warm() error
+}
+
+type ResultCache interface {
+	Get(key cache.Key) (int, bool)
+	Set(key cache.Key, result int)
+	SeedLen(image string, seed int) int
 }
 
 // NewCachedDeduplicator creates CachedDeduplicator.
-func NewCachedDeduplicator(l *zap.SugaredLogger, seed int) (*CachedDeduplicator, error) {
-	d, err := NewRequestDeduplicator(l.Named("dp"), seed)
+func NewCachedDeduplicator(
+	l *zap.SugaredLogger, spec containers.ImageSpec, seed int, c ResultCache, cfg Config, adopted ...*containers.Qual,
+) (*CachedDeduplicator, error) {
+	d, err := NewRequestDeduplicator(l.Named("dp"), spec, seed, cfg, adopted...)
 	if err != nil {
 		return nil, fmt.Errorf("cannot create deduplicator: %w", err)
 	}
 
 	return &CachedDeduplicator{
-		l: l, d: d,
-		mu:            sync.RWMutex{},
-		inputToResult: make(map[int]int),
+		l: l, image: spec.Name, seed: seed, d: d,
+		cache: c, onMiss: cfg.OnMiss,
 	}, nil
 }
 
 // Calculate gets the result from cache or calls RequestDeduplicator.Calculate.
 func (cd *CachedDeduplicator) Calculate(ctx context.Context, input int) (int, error) {
-	cd.mu.RLock()
-	if res, ok := cd.inputToResult[input]; ok {
-		cd.mu.RUnlock()
+	if err := cd.getRejected(input); err != nil {
+		metrics.CacheHits.Inc()
+		return 0, err
+	}
+
+	key := cache.Key{Image: cd.image, Seed: cd.seed, Input: input}
+	if res, ok := cd.cache.Get(key); ok {
+		metrics.CacheHits.Inc()
 		cd.l.Infof("input %d, got result from cache: %d", input, res)
 		return res, nil
 	}
-	cd.mu.RUnlock()
+	metrics.CacheMisses.Inc()
+	if cd.onMiss != nil {
+		cd.onMiss()
+	}
 
 	res, err := cd.d.Calculate(ctx, input)
 	if err != nil {
-		return 0, fmt.Errorf("cannot get res from requestDedulpicator %d: %w", input, err)
+		err = fmt.Errorf("cannot get res from requestDedulpicator %d: %w", input, err)
+		if errors.Is(err, containers.ErrBadInput) {
+			cd.setRejected(input, err)
+		}
+		return 0, err
 	}
 
-	cd.mu.Lock()
-	defer cd.mu.Unlock()
-	cd.inputToResult[input] = res
+	cd.cache.Set(key, res)
 
 	cd.l.Infof("saved res %d for input %d to a cache", res, input)
 	return res, nil
 }
 
+func (cd *CachedDeduplicator) getRejected(input int) error {
+	cd.rejectedMu.Lock()
+	defer cd.rejectedMu.Unlock()
+
+	return cd.rejected[input]
+}
+
+// setRejected remembers the error of the input, an arbitrary one is forgotten when there are too many.
+func (cd *CachedDeduplicator) setRejected(input int, err error) {
+	cd.rejectedMu.Lock()
+	defer cd.rejectedMu.Unlock()
+
+	if cd.rejected == nil {
+		cd.rejected = make(map[int]error)
+	}
+	if len(cd.rejected) >= maxRejected {
+		for other := range cd.rejected {
+			delete(cd.rejected, other)
+			break
+		}
+	}
+	cd.rejected[input] = err
+}
+
+// SetConfig applies the config to containers of underlying RequestDeduplicator.
+func (cd *CachedDeduplicator) SetConfig(cfg containers.Config) error {
+	return cd.d.SetConfig(cfg)
+}
+
+// Warm starts containers of underlying RequestDeduplicator.
+func (cd *CachedDeduplicator) Warm() error {
+	return cd.d.Warm()
+}
+
+// SetIdleTimeout overrides the idle timeout of containers of underlying RequestDeduplicator.
+func (cd *CachedDeduplicator) SetIdleTimeout(idle time.Duration) {
+	cd.d.SetIdleTimeout(idle)
+}
+
+// SetPinned pins containers of underlying RequestDeduplicator.
+func (cd *CachedDeduplicator) SetPinned(pinned bool) {
+	cd.d.SetPinned(pinned)
+}
+
+// Status returns a snapshot of underlying RequestDeduplicator with the count of cached results.
+func (cd *CachedDeduplicator) Status() snapshot.Deduplicator {
+	s := cd.d.Status()
+	s.CachedResults = cd.cache.SeedLen(cd.image, cd.seed)
+
+	return s
+}
+
 // Close closes underlying RequestDeduplicator.
 func (cd *CachedDeduplicator) Close() error {
 	return cd.d.Close()
@@ -2,10 +2,13 @@ package deduplicator
 
 import (
 	"context"
-	"sync"
+	"fmt"
 	"testing"
 
+	"github.com/Snyssfx/container_scheduler/internal/cache"
+	"github.com/Snyssfx/container_scheduler/internal/containers"
 	"github.com/Snyssfx/container_scheduler/internal/deduplicator/mock"
+	"github.com/Snyssfx/container_scheduler/internal/snapshot"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
@@ -18,11 +21,16 @@ func TestCachedDeduplicator_Calculate(t *testing.T) {
 		nCalls++
 		return 2, nil
 	})
+	c, err := cache.New(zap.NewNop().Sugar(), cache.Config{Policy: cache.LRU})
+	require.NoError(t, err)
+	var nMisses int
 	cd := &CachedDeduplicator{
-		l:             zap.NewNop().Sugar(),
-		d:             d,
-		mu:            sync.RWMutex{},
-		inputToResult: map[int]int{},
+		l:      zap.NewNop().Sugar(),
+		image:  "qual-2021",
+		seed:   1,
+		d:      d,
+		cache:  c,
+		onMiss: func() { nMisses++ },
 	}
 
 	got, err := cd.Calculate(context.Background(), 1)
@@ -33,4 +41,101 @@ func TestCachedDeduplicator_Calculate(t *testing.T) {
 	assert.Equal(t, 2, got)
 
 	assert.Equal(t, nCalls, 1)
+	assert.Equal(t, 1, nMisses)
+}
+
+func TestCachedDeduplicator_Calculate_Evicted(t *testing.T) {
+	inputToCalls := map[int]int{}
+	d := mock.NewRequestDeduplicatorMock(t)
+	d.CalculateMock.Set(func(ctx context.Context, input int) (i1 int, err error) {
+		inputToCalls[input]++
+		return input, nil
+	})
+	c, err := cache.New(zap.NewNop().Sugar(), cache.Config{Policy: cache.LRU, MaxEntries: 1})
+	require.NoError(t, err)
+	cd := &CachedDeduplicator{
+		l:     zap.NewNop().Sugar(),
+		image: "qual-2021",
+		seed:  1,
+		d:     d,
+		cache: c,
+	}
+
+	for _, input := range []int{1, 2, 1} {
+		got, err := cd.Calculate(context.Background(), input)
+		require.NoError(t, err)
+		assert.Equal(t, input, got)
+	}
+
+	assert.Equal(t, map[int]int{1: 2, 2: 1}, inputToCalls)
+}
+
+func TestCachedDeduplicator_Status(t *testing.T) {
+	d := mock.NewRequestDeduplicatorMock(t)
+	d.StatusMock.Return(snapshot.Deduplicator{Queued: []snapshot.Input{{Input: 2, Subscribers: 1}}})
+	c, err := cache.New(zap.NewNop().Sugar(), cache.Config{Policy: cache.LRU})
+	require.NoError(t, err)
+	c.Set(cache.Key{Image: "qual-2021", Seed: 1, Input: 1}, 10)
+	c.Set(cache.Key{Image: "qual-2021", Seed: 2, Input: 1}, 10)
+	cd := &CachedDeduplicator{l: zap.NewNop().Sugar(), image: "qual-2021", seed: 1, d: d, cache: c}
+
+	assert.Equal(t, snapshot.Deduplicator{
+		Queued:        []snapshot.Input{{Input: 2, Subscribers: 1}},
+		CachedResults: 1,
+	}, cd.Status())
+}
+
+func TestCachedDeduplicator_Calculate_Rejected(t *testing.T) {
+	inputToCalls := map[int]int{}
+	d := mock.NewRequestDeduplicatorMock(t)
+	d.CalculateMock.Set(func(ctx context.Context, input int) (i1 int, err error) {
+		inputToCalls[input]++
+		if input == 1 {
+			return 0, fmt.Errorf("cannot calculate input 1: %w", containers.ErrBadInput)
+		}
+		return 0, fmt.Errorf("cannot calculate input %d: %w", input, containers.ErrUpstreamFailure)
+	})
+	c, err := cache.New(zap.NewNop().Sugar(), cache.Config{Policy: cache.LRU})
+	require.NoError(t, err)
+	cd := &CachedDeduplicator{
+		l:     zap.NewNop().Sugar(),
+		image: "qual-2021",
+		seed:  1,
+		d:     d,
+		cache: c,
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = cd.Calculate(context.Background(), 1)
+		assert.ErrorIs(t, err, containers.ErrBadInput)
+		_, err = cd.Calculate(context.Background(), 2)
+		assert.ErrorIs(t, err, containers.ErrUpstreamFailure)
+	}
+
+	assert.Equal(t, map[int]int{1: 1, 2: 2}, inputToCalls)
+	assert.Zero(t, c.SeedLen("qual-2021", 1))
+}
+
+func TestCachedDeduplicator_Calculate_BreakerOpen(t *testing.T) {
+	c := mock.NewContainerMock(t)
+	c.CalculateMock.Return(0, fmt.Errorf("cannot calculate: %w", containers.ErrUnreachable))
+	r, closeFn := newTestDeduplicatorWithContainers(t, 1, c)
+	defer closeFn()
+	b := &fakeBreaker{}
+	r.breaker = b
+	results, err := cache.New(zap.NewNop().Sugar(), cache.Config{Policy: cache.LRU})
+	require.NoError(t, err)
+	results.Set(cache.Key{Image: "qual-2021", Seed: 1, Input: 1}, 2)
+	cd := &CachedDeduplicator{l: zap.NewNop().Sugar(), image: "qual-2021", seed: 1, d: r, cache: results}
+
+	_, err = cd.Calculate(context.Background(), 2)
+	require.ErrorIs(t, err, containers.ErrUnreachable)
+	_, err = cd.Calculate(context.Background(), 3)
+	require.ErrorIs(t, err, errOpen)
+
+	// cached results are served while the breaker is open, they are not probes
+	got, err := cd.Calculate(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, got)
+	assert.Len(t, b.reports, 1)
 }
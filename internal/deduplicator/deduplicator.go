@@ -4,20 +4,40 @@ package deduplicator
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/Snyssfx/container_scheduler/internal/containers"
+	"github.com/Snyssfx/container_scheduler/internal/metrics"
+	"github.com/Snyssfx/container_scheduler/internal/progress"
+	"github.com/Snyssfx/container_scheduler/internal/snapshot"
 	"go.uber.org/atomic"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 )
 
+var (
+	// ErrCanceled is returned when a request is canceled before its result is calculated.
+	ErrCanceled = errors.New("request was canceled")
+	// ErrClosed is returned for requests to a closed deduplicator.
+	ErrClosed = errors.New("deduplicator is closed")
+)
+
 // RequestDeduplicator holds subscriptions to calculations for all incoming requests for a container,
 // deduplicate calculations and publish a result for all subscribers.
+// Distinct inputs are calculated concurrently by workers, each worker is bound to one of
+// the containers of the seed.
 type RequestDeduplicator struct {
 	l           *zap.SugaredLogger
+	image       string
 	seed        int
-	container   container
+	containers  []container
+	concurrency int
+	scheduling  SchedulingPolicy
+	breaker     Breaker
 	reqID       *atomic.Int64
 	closeCtx    context.Context
 	closeLoopFn context.CancelFunc
@@ -26,28 +46,117 @@ type RequestDeduplicator struct {
 
 	mu                  sync.Mutex
 	inputToSubsriptions map[int]map[int]*subscription
-	curInput            int
-	cancelCurCalcFn     context.CancelFunc
+	inputToCancelCalcFn map[int]context.CancelFunc
+	// inputToLastEvent holds the last progress of inputs being calculated for new subscribers.
+	inputToLastEvent map[int]progress.Event
+	// positionsSeq numbers snapshots of the queue for position reports.
+	positionsSeq uint64
+
+	// positionsMu serializes position reports, they are counted and sent outside mu.
+	positionsMu sync.Mutex
+	// reportedSeq is the number of the last reported snapshot, older ones are dropped.
+	reportedSeq uint64
+}
+
+// Config holds settings of a RequestDeduplicator.
+type Config struct {
+	// Replicas is a count of containers for one seed.
+	Replicas int
+	// Concurrency is a count of simultaneous calculations in one container.
+	Concurrency int
+	// Scheduling chooses the next input, MostSubscribers if it is nil.
+	Scheduling SchedulingPolicy
+	// Breaker fails calculations of the seed fast after failures of its containers,
+	// nil disables it.
+	Breaker Breaker
+	// OnMiss is called for every request of the seed that misses the cache, it may be nil.
+	OnMiss     func()
+	Containers containers.Config
+}
+
+// Validate checks that the config is consistent.
+func (cfg Config) Validate() error {
+	if cfg.Replicas < 1 || cfg.Concurrency < 1 {
+		return fmt.Errorf("replicas %d and concurrency %d should be positive", cfg.Replicas, cfg.Concurrency)
+	}
+
+	err := cfg.Containers.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid containers config: %w", err)
+	}
+
+	return nil
+}
+
+// Breaker is a circuit breaker of a seed.
+type Breaker interface {
+	// Allow is called before a calculation is requested, a non-zero probe has to be ended.
+	Allow() (probe uint64, err error)
+	// Report is called once with the outcome of every calculation or warm up and the probe
+	// whose request started the calculation, 0 if other requests started it.
+	Report(probe uint64, err error)
+	// EndProbe is called when the allowed probe request is finished.
+	EndProbe(probe uint64)
 }
 
 type container interface {
 	Calculate(ctx context.Context, input int) (int, error)
 	Close() error
+	SetConfig(cfg containers.Config) error
+	SetIdleTimeout(idle time.Duration)
+	SetPinned(pinned bool)
+	Status() snapshot.Container
+	Warm() error
 }
 
-// NewRequestDeduplicator creates RequestDeduplicator.
-func NewRequestDeduplicator(l *zap.SugaredLogger, seed int) (*RequestDeduplicator, error) {
-	q, err := containers.NewQual(l.Named("qual"), seed)
+// NewRequestDeduplicator creates RequestDeduplicator. Adopted containers of a previous run
+// are used as replicas first, the ones over the replicas count are closed.
+func NewRequestDeduplicator(
+	l *zap.SugaredLogger, spec containers.ImageSpec, seed int, cfg Config, adopted ...*containers.Qual,
+) (*RequestDeduplicator, error) {
+	err := cfg.Validate()
 	if err != nil {
-		return nil, fmt.Errorf("cannot create qual: %w", err)
+		return nil, err
+	}
+
+	cs := make([]container, 0, cfg.Replicas)
+	for _, q := range adopted {
+		if len(cs) == cfg.Replicas {
+			err = q.Close()
+			if err != nil {
+				l.Errorf("cannot close extra adopted qual: %s", err.Error())
+			}
+			continue
+		}
+		cs = append(cs, q)
+	}
+
+	for len(cs) < cfg.Replicas {
+		q, err := containers.NewQual(l.Named("qual"), spec, seed, cfg.Containers)
+		if err != nil {
+			for _, c := range cs {
+				_ = c.Close()
+			}
+			return nil, fmt.Errorf("cannot create qual: %w", err)
+		}
+		cs = append(cs, q)
+	}
+
+	scheduling := cfg.Scheduling
+	if scheduling == nil {
+		scheduling = MostSubscribers{}
 	}
 
 	ctx, closer := context.WithCancel(context.Background())
 
 	d := &RequestDeduplicator{
 		l:           l,
+		image:       spec.Name,
 		seed:        seed,
-		container:   q,
+		containers:  cs,
+		concurrency: cfg.Concurrency,
+		scheduling:  scheduling,
+		breaker:     cfg.Breaker,
 		reqID:       atomic.NewInt64(0),
 		closeCtx:    ctx,
 		closeLoopFn: closer,
@@ -56,6 +165,8 @@ func NewRequestDeduplicator(l *zap.SugaredLogger, seed int) (*RequestDeduplicato
 
 		mu:                  sync.Mutex{},
 		inputToSubsriptions: make(map[int]map[int]*subscription),
+		inputToCancelCalcFn: make(map[int]context.CancelFunc),
+		inputToLastEvent:    make(map[int]progress.Event),
 	}
 
 	go d.Start()
@@ -64,94 +175,262 @@ func NewRequestDeduplicator(l *zap.SugaredLogger, seed int) (*RequestDeduplicato
 }
 
 // Calculate subscribe user to a calculation, and wait for result.
+// Requests of a seed with an open breaker fail fast.
 func (r *RequestDeduplicator) Calculate(ctx context.Context, input int) (int, error) {
+	var probe uint64
+	if r.breaker != nil {
+		var err error
+		probe, err = r.breaker.Allow()
+		if err != nil {
+			return 0, err
+		}
+		if probe != 0 {
+			defer r.breaker.EndProbe(probe)
+		}
+	}
+
 	reqID := r.reqID.Inc()
-	sub := r.subscribe(input, int(reqID))
+	deadline, _ := ctx.Deadline()
+	sub := r.subscribe(input, int(reqID), probe, deadline, progress.FromContext(ctx))
 	defer r.unsubscribe(input, int(reqID))
 
-	select {
-	case r.signalOfNewSub <- true:
-	default: // a signal from another sub has already been sent.
-	}
+	r.signal()
 
 	select {
 
 	case <-ctx.Done():
-		return 0, fmt.Errorf("request was canceled: %d, %d", input, reqID)
+		return 0, fmt.Errorf("%w: %d, %d: %s", ErrCanceled, input, reqID, ctx.Err())
+
+	case <-r.closeCtx.Done():
+		return 0, fmt.Errorf("%w: %d, %d", ErrClosed, input, reqID)
 
-	case res, opened := <-sub.resultCh:
-		if !opened {
-			r.l.Panic("unexpected result ch closing")
+	case o := <-sub.resultCh:
+		return o.result, o.err
+	}
+}
+
+// signal wakes up one idle worker.
+func (r *RequestDeduplicator) signal() {
+	select {
+	case r.signalOfNewSub <- true:
+	default: // a signal from another sub has already been sent.
+	}
+}
+
+// Start runs workers for every container and waits for closing.
+func (r *RequestDeduplicator) Start() {
+	for _, c := range r.containers {
+		for i := 0; i < r.concurrency; i++ {
+			go r.work(c)
 		}
-		return res, nil
 	}
+
+	<-r.closeCtx.Done()
 }
 
-// Start is an infinite loop when deduplicator choose next input for calculation,
+// work is an infinite loop when a worker choose next input for calculation,
 // sends it to the container and publish results.
-func (r *RequestDeduplicator) Start() {
+func (r *RequestDeduplicator) work(c container) {
 	for {
 		select {
 		case <-r.closeCtx.Done():
 			return
 		case <-r.signalOfNewSub:
-			for {
-				input, result, inputValid, err := r.calculateNextInput()
-				if err != nil {
-					if inputValid {
-						r.l.Errorf("cannot calculate: %s", err.Error())
-						r.unsubscribeAll(input)
-					}
+			// inputs left after closing are not calculated, their subscribers get ErrClosed
+			for r.closeCtx.Err() == nil {
+				input, o, inputValid := r.calculateNextInput(c)
+				if !inputValid {
 					break
 				}
 
-				r.publish(input, result)
+				if o.err != nil {
+					r.l.Errorf("cannot calculate: %s", o.err.Error())
+				}
+				// the outcome is reported before subscribers get it, so a probe sees it
+				if r.breaker != nil && !o.canceled {
+					r.breaker.Report(o.probe, o.err)
+				}
+				r.publish(input, o)
 			}
 		}
 	}
 }
 
-func (r *RequestDeduplicator) calculateNextInput() (input, result int, inputValid bool, err error) {
+func (r *RequestDeduplicator) calculateNextInput(c container) (input int, o outcome, inputValid bool) {
 	r.mu.Lock()
 
-	input, err = r.chooseNextInput()
+	input, err := r.chooseNextInput()
 	if err != nil {
 		r.mu.Unlock()
-		return 0, 0, false, fmt.Errorf("cannot choose next input: %w", err)
+		return 0, outcome{}, false
 	}
 
 	ctx, cancelFn := context.WithCancel(context.Background())
+	ctx = progress.WithReporter(ctx, func(e progress.Event) { r.report(input, e) })
+	r.inputToCancelCalcFn[input] = cancelFn
+	// a probe that joins the calculation later does not own its outcome
+	var probe uint64
+	for _, sub := range r.inputToSubsriptions[input] {
+		sub.setQueued(false)
+		if sub.probe != 0 {
+			probe = sub.probe
+		}
+	}
 
-	r.curInput, r.cancelCurCalcFn = input, cancelFn
+	// other inputs are waiting, so wake up another worker.
+	if len(r.inputToSubsriptions) > len(r.inputToCancelCalcFn) {
+		r.signal()
+	}
+	positions := r.positionsSnapshot()
 	r.mu.Unlock()
+	r.reportPositions(positions)
 
-	result, err = r.calculateInput(ctx, input)
-
-	r.mu.Lock()
-	r.curInput, r.cancelCurCalcFn = 0, nil
-	r.mu.Unlock()
+	// the input stays in progress until publish, so other workers do not take it.
+	result, err := r.calculateInput(ctx, c, input)
+	o = outcome{result: result, err: err, canceled: err != nil && ctx.Err() != nil, probe: probe}
+	cancelFn()
 
-	return input, result, true, err
+	return input, o, true
 }
 
+// chooseNextInput asks the scheduling policy for one of inputs that are not being calculated.
+// It is called under the mutex.
 func (r *RequestDeduplicator) chooseNextInput() (int, error) {
-	if len(r.inputToSubsriptions) == 0 {
-		return 0, fmt.Errorf("empty input to subcriptions")
+	queue := r.queue()
+	if len(queue) == 0 {
+		return 0, fmt.Errorf("no inputs waiting for calculation")
 	}
 
-	inputWithMaxSubs, i := 0, 0
+	return queue[r.scheduling.Choose(time.Now(), queue)].Input, nil
+}
+
+// queue returns inputs that are not being calculated ordered by the oldest waiter.
+// It is called under the mutex.
+func (r *RequestDeduplicator) queue() []QueuedInput {
+	queue := make([]QueuedInput, 0, len(r.inputToSubsriptions))
 	for input, subs := range r.inputToSubsriptions {
-		if i == 0 || len(subs) > len(r.inputToSubsriptions[inputWithMaxSubs]) {
-			inputWithMaxSubs = input
+		if _, calculating := r.inputToCancelCalcFn[input]; calculating {
+			continue
 		}
-		i++
+
+		queue = append(queue, newQueuedInput(input, subs))
 	}
 
-	return inputWithMaxSubs, nil
+	sort.Slice(queue, func(i, j int) bool {
+		if !queue[i].OldestWait.Equal(queue[j].OldestWait) {
+			return queue[i].OldestWait.Before(queue[j].OldestWait)
+		}
+		return queue[i].Input < queue[j].Input
+	})
+
+	return queue
 }
 
-func (r *RequestDeduplicator) calculateInput(ctx context.Context, input int) (int, error) {
-	result, err := r.container.Calculate(ctx, input)
+// positions is a snapshot of the queue with subscribers that listen for progress.
+type positions struct {
+	seq   uint64
+	now   time.Time
+	queue []QueuedInput
+	subs  map[int][]*subscription
+}
+
+// positionsSnapshot copies the queue for reportPositions, it is nil if nobody listens for
+// progress of waiting inputs. It is called under the mutex.
+func (r *RequestDeduplicator) positionsSnapshot() *positions {
+	var subs map[int][]*subscription
+	for input, inputSubs := range r.inputToSubsriptions {
+		if _, calculating := r.inputToCancelCalcFn[input]; calculating {
+			continue
+		}
+
+		for _, sub := range inputSubs {
+			if sub.report == nil {
+				continue
+			}
+			if subs == nil {
+				subs = make(map[int][]*subscription)
+			}
+			subs[input] = append(subs[input], sub)
+		}
+	}
+	if subs == nil {
+		return nil
+	}
+
+	r.positionsSeq++
+	return &positions{seq: r.positionsSeq, now: time.Now(), queue: r.queue(), subs: subs}
+}
+
+// reportPositions ranks the snapshot of the queue once and reports changed positions to
+// subscribers that listen for progress. It is called outside the mutex, so snapshots
+// taken earlier than the reported one are dropped.
+func (r *RequestDeduplicator) reportPositions(p *positions) {
+	if p == nil {
+		return
+	}
+
+	r.positionsMu.Lock()
+	defer r.positionsMu.Unlock()
+
+	if p.seq < r.reportedSeq {
+		return
+	}
+	r.reportedSeq = p.seq
+
+	for i, q := range r.rank(p.now, p.queue) {
+		for _, sub := range p.subs[q.Input] {
+			sub.reportPosition(i + 1)
+		}
+	}
+}
+
+// rank sorts the queue in the order the scheduling policy would choose inputs if nothing
+// changed. An input goes first if the policy chooses it even when it is placed second,
+// so ties keep the order of the queue.
+func (r *RequestDeduplicator) rank(now time.Time, queue []QueuedInput) []QueuedInput {
+	pair := make([]QueuedInput, 2)
+	sort.SliceStable(queue, func(i, j int) bool {
+		pair[0], pair[1] = queue[j], queue[i]
+		return r.scheduling.Choose(now, pair) == 1
+	})
+
+	return queue
+}
+
+// report passes the event of the input to its subscribers.
+func (r *RequestDeduplicator) report(input int, e progress.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, calculating := r.inputToCancelCalcFn[input]; !calculating {
+		return
+	}
+	r.inputToLastEvent[input] = e
+
+	for _, sub := range r.inputToSubsriptions[input] {
+		if sub.report != nil {
+			sub.report(e)
+		}
+	}
+}
+
+func newQueuedInput(input int, subs map[int]*subscription) QueuedInput {
+	q := QueuedInput{Input: input, Subscribers: len(subs)}
+	for _, sub := range subs {
+		if q.OldestWait.IsZero() || sub.subscribedAt.Before(q.OldestWait) {
+			q.OldestWait = sub.subscribedAt
+		}
+
+		if !sub.deadline.IsZero() && (q.EarliestDeadline.IsZero() || sub.deadline.Before(q.EarliestDeadline)) {
+			q.EarliestDeadline = sub.deadline
+		}
+	}
+
+	return q
+}
+
+func (r *RequestDeduplicator) calculateInput(ctx context.Context, c container, input int) (int, error) {
+	result, err := c.Calculate(ctx, input)
 	if err != nil {
 		return 0, fmt.Errorf("cannot calculate input %d: %w", input, err)
 	}
@@ -159,85 +438,252 @@ func (r *RequestDeduplicator) calculateInput(ctx context.Context, input int) (in
 	return result, nil
 }
 
-// Close stops calculation loop and the container.
+// Close stops calculation loop and the containers.
 func (r *RequestDeduplicator) Close() error {
 	r.closeLoopFn()
-	err := r.container.Close()
+
+	errs := make([]error, len(r.containers))
+	wg := sync.WaitGroup{}
+	for i, c := range r.containers {
+		wg.Add(1)
+		i, c := i, c
+		go func() {
+			defer wg.Done()
+
+			err := c.Close()
+			if err != nil {
+				errs[i] = fmt.Errorf("cannot shutdown container: %w", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	err := multierr.Combine(errs...)
 	if err != nil {
-		return fmt.Errorf("cannot shutdown container: %w", err)
+		return err
 	}
 
-	r.l.Infof("deduplicator %d closed", r.seed)
+	r.l.Infof("deduplicator %s %d closed", r.image, r.seed)
 	return nil
 }
 
-// subscription holds a channel with the result for a user.
+// Warm starts all containers of the seed in parallel and waits for their initialization.
+func (r *RequestDeduplicator) Warm() error {
+	errs := make([]error, len(r.containers))
+	wg := sync.WaitGroup{}
+	for i, c := range r.containers {
+		wg.Add(1)
+		i, c := i, c
+		go func() {
+			defer wg.Done()
+
+			err := c.Warm()
+			if err != nil {
+				errs[i] = fmt.Errorf("cannot warm container: %w", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	err := multierr.Combine(errs...)
+	if r.breaker != nil {
+		r.breaker.Report(0, err)
+	}
+
+	return err
+}
+
+// Status returns a snapshot of the deduplicator and its containers.
+func (r *RequestDeduplicator) Status() snapshot.Deduplicator {
+	s := snapshot.Deduplicator{
+		Containers:  make([]snapshot.Container, 0, len(r.containers)),
+		Calculating: []snapshot.Input{},
+		Queued:      []snapshot.Input{},
+	}
+	for _, c := range r.containers {
+		s.Containers = append(s.Containers, c.Status())
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for input := range r.inputToCancelCalcFn {
+		s.Calculating = append(s.Calculating, snapshot.Input{Input: input, Subscribers: len(r.inputToSubsriptions[input])})
+	}
+	sort.Slice(s.Calculating, func(i, j int) bool { return s.Calculating[i].Input < s.Calculating[j].Input })
+
+	for _, q := range r.rank(time.Now(), r.queue()) {
+		s.Queued = append(s.Queued, snapshot.Input{Input: q.Input, Subscribers: q.Subscribers})
+	}
+
+	return s
+}
+
+// SetIdleTimeout overrides the idle timeout of all containers of the seed, 0 returns the adaptive one
+// or the one of the config.
+func (r *RequestDeduplicator) SetIdleTimeout(idle time.Duration) {
+	for _, c := range r.containers {
+		c.SetIdleTimeout(idle)
+	}
+}
+
+// SetPinned keeps all containers of the seed running after the idle timeout or returns them back.
+func (r *RequestDeduplicator) SetPinned(pinned bool) {
+	for _, c := range r.containers {
+		c.SetPinned(pinned)
+	}
+}
+
+// SetConfig applies the config to all containers of the seed.
+func (r *RequestDeduplicator) SetConfig(cfg containers.Config) error {
+	err := cfg.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid containers config: %w", err)
+	}
+
+	for _, c := range r.containers {
+		err = c.SetConfig(cfg)
+		if err != nil {
+			return fmt.Errorf("cannot set container config: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// outcome is a result of a calculation or its error.
+type outcome struct {
+	result int
+	err    error
+	// canceled is set if the calculation failed after all its subscribers had left.
+	canceled bool
+	// probe is the breaker probe whose request started the calculation, 0 if there is none.
+	probe uint64
+}
+
+// subscription holds a channel with the outcome for a user.
 type subscription struct {
-	resultCh chan int
+	resultCh     chan outcome
+	subscribedAt time.Time
+	deadline     time.Time
+	// report is nil if the user does not listen for progress.
+	report progress.Reporter
+	// probe is the breaker probe of the request, 0 if it is not a probe.
+	probe uint64
+
+	// mu orders position reports with the start of the calculation, so a position
+	// is not reported after it.
+	mu       sync.Mutex
+	queued   bool
+	position int
 }
 
-func newSubscription() *subscription {
+func newSubscription(deadline time.Time, report progress.Reporter) *subscription {
 	return &subscription{
-		resultCh: make(chan int, 1),
+		resultCh:     make(chan outcome, 1),
+		subscribedAt: time.Now(),
+		deadline:     deadline,
+		report:       report,
 	}
 }
 
-func (s *subscription) close() {
-	close(s.resultCh)
+// reportPosition reports the queue position if the input is queued and the position is changed.
+func (s *subscription) reportPosition(position int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.report == nil || !s.queued || s.position == position {
+		return
+	}
+
+	s.position = position
+	s.report(progress.Event{Stage: progress.StageQueued, Position: position})
+}
+
+// setQueued marks the input of the subscription as waiting or being calculated,
+// a queued again input reports its position anew.
+func (s *subscription) setQueued(queued bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.queued = queued
+	s.position = 0
 }
-func (r *RequestDeduplicator) subscribe(input, reqID int) *subscription {
+
+func (r *RequestDeduplicator) subscribe(input, reqID int, probe uint64, deadline time.Time, report progress.Reporter) *subscription {
 	r.mu.Lock()
-	defer r.mu.Unlock()
 
-	sub := newSubscription()
+	sub := newSubscription(deadline, report)
+	sub.probe = probe
+	_, calculating := r.inputToCancelCalcFn[input]
+	sub.queued = !calculating
 	if len(r.inputToSubsriptions[input]) == 0 {
 		r.inputToSubsriptions[input] = make(map[int]*subscription)
+		metrics.QueueDepth.Inc()
 	}
 
 	r.inputToSubsriptions[input][reqID] = sub
+	if e, ok := r.inputToLastEvent[input]; ok && report != nil {
+		report(e)
+	}
+	positions := r.positionsSnapshot()
+	r.mu.Unlock()
+
+	r.reportPositions(positions)
 	return sub
 }
 
 func (r *RequestDeduplicator) unsubscribe(input, reqID int) {
 	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	sub := r.inputToSubsriptions[input][reqID]
-	if sub != nil {
-		sub.close()
-	}
 
 	delete(r.inputToSubsriptions[input], reqID)
 
-	if len(r.inputToSubsriptions[input]) == 0 {
+	if _, ok := r.inputToSubsriptions[input]; ok && len(r.inputToSubsriptions[input]) == 0 {
 		delete(r.inputToSubsriptions, input)
-		if r.curInput == input && r.cancelCurCalcFn != nil {
-			r.cancelCurCalcFn()
+		metrics.QueueDepth.Dec()
+		if cancelFn, ok := r.inputToCancelCalcFn[input]; ok {
+			cancelFn()
 		}
 	}
+	positions := r.positionsSnapshot()
+	r.mu.Unlock()
+
+	r.reportPositions(positions)
 }
 
-func (r *RequestDeduplicator) unsubscribeAll(input int) {
+// publish sends the outcome of the input to all its subscribers.
+func (r *RequestDeduplicator) publish(input int, o outcome) {
 	r.mu.Lock()
-	defer r.mu.Unlock()
 
-	for _, sub := range r.inputToSubsriptions[input] {
-		sub.close()
-	}
-	delete(r.inputToSubsriptions, input)
-	if r.curInput == input && r.cancelCurCalcFn != nil {
-		r.cancelCurCalcFn()
+	delete(r.inputToCancelCalcFn, input)
+	delete(r.inputToLastEvent, input)
+
+	subs, ok := r.inputToSubsriptions[input]
+	if !ok {
+		r.mu.Unlock()
+		return
 	}
-}
 
-func (r *RequestDeduplicator) publish(input, result int) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	// new subscribers came after the calculation was canceled, so it is queued again
+	if o.canceled {
+		for _, sub := range subs {
+			sub.setQueued(true)
+		}
+		r.signal()
+		positions := r.positionsSnapshot()
+		r.mu.Unlock()
+		r.reportPositions(positions)
+		return
+	}
 
-	for _, sub := range r.inputToSubsriptions[input] {
-		sub.resultCh <- result
-		sub.close()
+	for _, sub := range subs {
+		sub.resultCh <- o
 	}
 
 	delete(r.inputToSubsriptions, input)
+	metrics.QueueDepth.Dec()
+	if o.err == nil {
+		metrics.DedupFanOut.Observe(float64(len(subs)))
+	}
+	r.mu.Unlock()
 }
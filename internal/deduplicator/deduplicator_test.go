@@ -4,10 +4,15 @@ package deduplicator
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/Snyssfx/container_scheduler/internal/containers"
 	"github.com/Snyssfx/container_scheduler/internal/deduplicator/mock"
+	"github.com/Snyssfx/container_scheduler/internal/progress"
+	"github.com/Snyssfx/container_scheduler/internal/snapshot"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/atomic"
@@ -99,6 +104,434 @@ func TestRequestDeduplicator_Calculate_1000RequestsAllUsubscribes(t *testing.T)
 	wg.Wait()
 }
 
+func TestRequestDeduplicator_Calculate_Workers(t *testing.T) {
+	var inFlight, maxInFlight int
+	inputToCalls := map[int]int{}
+	mu := sync.Mutex{}
+	release := make(chan struct{})
+
+	c := mock.NewContainerMock(t)
+	c.CalculateMock.Set(func(ctx context.Context, input int) (i1 int, err error) {
+		mu.Lock()
+		inFlight++
+		inputToCalls[input]++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		<-release
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return input, nil
+	})
+	r, closeFn := newTestDeduplicatorWithContainers(t, 3, c, c)
+	defer closeFn()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 60; i++ {
+		wg.Add(1)
+		i := i
+		go func() {
+			defer wg.Done()
+			res, err := r.Calculate(context.Background(), i%6)
+
+			require.NoError(t, err)
+			assert.Equal(t, i%6, res)
+		}()
+	}
+
+	waitSubscribers(t, r, 60)
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return inFlight == 6
+	}, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, 6, maxInFlight)
+	for input, calls := range inputToCalls {
+		assert.Equal(t, 1, calls, "input %d", input)
+	}
+}
+
+func TestRequestDeduplicator_Calculate_Progress(t *testing.T) {
+	calculating := make(chan struct{})
+	release := make(chan struct{})
+	c := mock.NewContainerMock(t)
+	c.CalculateMock.Set(func(ctx context.Context, input int) (i1 int, err error) {
+		progress.Report(ctx, progress.Event{Stage: progress.StageCalculating})
+		if input == 1 {
+			close(calculating)
+		}
+		<-release
+		return input, nil
+	})
+	r, closeFn := newTestDeduplicatorWithContainers(t, 1, c)
+	defer closeFn()
+
+	mu := sync.Mutex{}
+	events := map[string][]progress.Event{}
+	wg := sync.WaitGroup{}
+	calculate := func(name string, input int) {
+		wg.Add(1)
+		ctx := progress.WithReporter(context.Background(), func(e progress.Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			events[name] = append(events[name], e)
+		})
+		go func() {
+			defer wg.Done()
+			_, err := r.Calculate(ctx, input)
+			assert.NoError(t, err)
+		}()
+	}
+	eventsOf := func(name string) []progress.Event {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]progress.Event(nil), events[name]...)
+	}
+
+	calculate("first", 1)
+	<-calculating
+	calculate("queued", 2)
+	require.Eventually(t, func() bool { return len(eventsOf("queued")) == 1 }, time.Second, time.Millisecond)
+	calculate("late", 1)
+	require.Eventually(t, func() bool { return len(eventsOf("late")) == 1 }, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	queued := progress.Event{Stage: progress.StageQueued, Position: 1}
+	calc := progress.Event{Stage: progress.StageCalculating}
+	assert.Equal(t, []progress.Event{queued, calc}, eventsOf("first"))
+	assert.Equal(t, []progress.Event{queued, calc}, eventsOf("queued"))
+	assert.Equal(t, []progress.Event{calc}, eventsOf("late"))
+}
+
+func TestRequestDeduplicator_Calculate_Positions(t *testing.T) {
+	calculating := make(chan struct{})
+	c := mock.NewContainerMock(t)
+	c.CalculateMock.Set(func(ctx context.Context, input int) (i1 int, err error) {
+		close(calculating)
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	c.StatusMock.Return(snapshot.Container{})
+	r, closeFn := newTestDeduplicatorWithContainers(t, 1, c)
+
+	mu := sync.Mutex{}
+	var positions []int
+	ctx := progress.WithReporter(context.Background(), func(e progress.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		positions = append(positions, e.Position)
+	})
+	positionsOf := func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]int(nil), positions...)
+	}
+
+	errs := make(chan error, 4)
+	calculate := func(ctx context.Context, input int) {
+		go func() {
+			_, err := r.Calculate(ctx, input)
+			errs <- err
+		}()
+	}
+	calculate(context.Background(), 1)
+	<-calculating
+	calculate(ctx, 2)
+	require.Eventually(t, func() bool { return len(positionsOf()) == 1 }, time.Second, time.Millisecond)
+	calculate(context.Background(), 3)
+	calculate(context.Background(), 3)
+
+	// the input with more subscribers is chosen first
+	require.Eventually(t, func() bool { return len(positionsOf()) == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, []int{1, 2}, positionsOf())
+
+	closeFn()
+	for i := 0; i < 4; i++ {
+		assert.ErrorIs(t, <-errs, ErrClosed)
+	}
+}
+
+func TestRequestDeduplicator_Status(t *testing.T) {
+	calculating := make(chan struct{})
+	c := mock.NewContainerMock(t)
+	c.CalculateMock.Set(func(ctx context.Context, input int) (i1 int, err error) {
+		close(calculating)
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	c.StatusMock.Return(snapshot.Container{Name: "qual_9090_seed_1", State: "ready"})
+	r, closeFn := newTestDeduplicatorWithContainers(t, 1, c)
+
+	errs := make(chan error, 5)
+	for _, input := range []int{1, 1, 2, 3, 3} {
+		go func(input int) {
+			_, err := r.Calculate(context.Background(), input)
+			errs <- err
+		}(input)
+		if input == 1 {
+			<-calculating
+		}
+	}
+
+	want := snapshot.Deduplicator{
+		Containers:  []snapshot.Container{{Name: "qual_9090_seed_1", State: "ready"}},
+		Calculating: []snapshot.Input{{Input: 1, Subscribers: 2}},
+		Queued:      []snapshot.Input{{Input: 3, Subscribers: 2}, {Input: 2, Subscribers: 1}},
+	}
+	require.Eventually(t, func() bool { return assert.ObjectsAreEqual(want, r.Status()) }, time.Second, time.Millisecond)
+
+	// waiting requests fail when the deduplicator is closed
+	closeFn()
+	for i := 0; i < 5; i++ {
+		err := <-errs
+		require.Error(t, err)
+		assert.ErrorIs(t, err, ErrClosed)
+	}
+}
+
+func TestRequestDeduplicator_Calculate_ContainerError(t *testing.T) {
+	errDocker := errors.New("docker is down")
+	release := make(chan struct{})
+	c := mock.NewContainerMock(t)
+	c.CalculateMock.Set(func(ctx context.Context, input int) (i1 int, err error) {
+		<-release
+		if input == 1 {
+			return 0, errDocker
+		}
+		return input, nil
+	})
+	c.StatusMock.Return(snapshot.Container{})
+	r, closeFn := newTestDeduplicatorWithContainers(t, 1, c)
+	defer closeFn()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		input := i%2 + 1
+		go func() {
+			defer wg.Done()
+
+			res, err := r.Calculate(context.Background(), input)
+
+			if input == 1 {
+				require.ErrorIs(t, err, errDocker)
+				assert.Contains(t, err.Error(), "cannot calculate input 1")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, 2, res)
+		}()
+	}
+	require.Eventually(t, func() bool {
+		s := r.Status()
+		return len(s.Calculating) == 1 && len(s.Queued) == 1 && s.Calculating[0].Subscribers+s.Queued[0].Subscribers == 100
+	}, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	// the failed input is calculated again for a new request
+	_, err := r.Calculate(context.Background(), 1)
+	assert.ErrorIs(t, err, errDocker)
+	assert.Equal(t, uint64(3), c.CalculateAfterCounter())
+}
+
+func TestRequestDeduplicator_Calculate_SubscribeAfterCancel(t *testing.T) {
+	calculating := make(chan struct{})
+	canceled := make(chan struct{})
+	resume := make(chan struct{})
+	c := mock.NewContainerMock(t)
+	c.CalculateMock.Set(func(ctx context.Context, input int) (i1 int, err error) {
+		if c.CalculateBeforeCounter() > 1 {
+			return input, nil
+		}
+		close(calculating)
+		<-ctx.Done()
+		close(canceled)
+		<-resume
+		return 0, ctx.Err()
+	})
+	c.StatusMock.Return(snapshot.Container{})
+	r, closeFn := newTestDeduplicatorWithContainers(t, 1, c)
+	defer closeFn()
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := r.Calculate(ctx, 1)
+		errs <- err
+	}()
+	<-calculating
+	cancelFn()
+	require.ErrorIs(t, <-errs, ErrCanceled)
+	<-canceled
+
+	// a new subscriber joins the canceled calculation before it is published
+	type result struct {
+		res int
+		err error
+	}
+	results := make(chan result, 1)
+	go func() {
+		res, err := r.Calculate(context.Background(), 1)
+		results <- result{res: res, err: err}
+	}()
+	require.Eventually(t, func() bool {
+		s := r.Status()
+		return len(s.Calculating) == 1 && s.Calculating[0].Subscribers == 1
+	}, time.Second, time.Millisecond)
+	close(resume)
+
+	got := <-results
+	require.NoError(t, got.err)
+	assert.Equal(t, 1, got.res)
+	assert.Equal(t, uint64(2), c.CalculateAfterCounter())
+}
+
+func TestRequestDeduplicator_Close_BetweenInputs(t *testing.T) {
+	calculating := make(chan struct{})
+	release := make(chan struct{})
+	c := mock.NewContainerMock(t)
+	c.CalculateMock.Set(func(ctx context.Context, input int) (i1 int, err error) {
+		close(calculating)
+		<-release
+		return input, nil
+	})
+	c.StatusMock.Return(snapshot.Container{})
+	r, closeFn := newTestDeduplicatorWithContainers(t, 1, c)
+
+	errs := make(chan error, 2)
+	for _, input := range []int{1, 2} {
+		go func(input int) {
+			_, err := r.Calculate(context.Background(), input)
+			errs <- err
+		}(input)
+		if input == 1 {
+			<-calculating
+		}
+	}
+	require.Eventually(t, func() bool { return len(r.Status().Queued) == 1 }, time.Second, time.Millisecond)
+
+	closeFn()
+	for i := 0; i < 2; i++ {
+		assert.ErrorIs(t, <-errs, ErrClosed)
+	}
+	close(release)
+
+	// the worker does not take the queued input after the calculation in progress
+	assert.Never(t, func() bool { return c.CalculateAfterCounter() > 1 }, 50*time.Millisecond, time.Millisecond)
+}
+
+// fakeBreaker opens after the first failure and records reports.
+type fakeBreaker struct {
+	mu      sync.Mutex
+	reports []error
+	probes  int
+	// probe is a number of the last allowed probe, reported are numbers of reports.
+	probe    uint64
+	reported []uint64
+}
+
+var errOpen = errors.New("circuit is open")
+
+func (b *fakeBreaker) Allow() (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.reports) > 0 && b.reports[len(b.reports)-1] != nil {
+		return 0, errOpen
+	}
+	b.probes++
+	b.probe++
+	return b.probe, nil
+}
+
+func (b *fakeBreaker) Report(probe uint64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.reports = append(b.reports, err)
+	b.reported = append(b.reported, probe)
+}
+
+func (b *fakeBreaker) EndProbe(uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probes--
+}
+
+func TestRequestDeduplicator_Calculate_Breaker(t *testing.T) {
+	errDocker := errors.New("docker is down")
+	release := make(chan struct{})
+	c := mock.NewContainerMock(t)
+	c.CalculateMock.Set(func(ctx context.Context, input int) (i1 int, err error) {
+		<-release
+		return 0, errDocker
+	})
+	c.StatusMock.Return(snapshot.Container{})
+	r, closeFn := newTestDeduplicatorWithContainers(t, 1, c)
+	defer closeFn()
+	b := &fakeBreaker{}
+	r.breaker = b
+
+	// deduplicated requests of one failed calculation are reported once
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.Calculate(context.Background(), 1)
+			assert.ErrorIs(t, err, errDocker)
+		}()
+	}
+	require.Eventually(t, func() bool {
+		s := r.Status()
+		return len(s.Calculating) == 1 && s.Calculating[0].Subscribers == 10
+	}, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Len(t, b.reports, 1)
+	assert.ErrorIs(t, b.reports[0], errDocker)
+	require.Len(t, b.reported, 1)
+	assert.NotZero(t, b.reported[0], "the outcome belongs to the probe that started the calculation")
+	assert.Zero(t, b.probes, "every allowed request ends its probe")
+
+	// an open breaker fails requests without calculations
+	_, err := r.Calculate(context.Background(), 2)
+	assert.ErrorIs(t, err, errOpen)
+	assert.Equal(t, uint64(1), c.CalculateAfterCounter())
+}
+
+func TestRequestDeduplicator_SetConfig(t *testing.T) {
+	cfg := containers.Config{
+		Runtime:               containers.RuntimeCLI,
+		InitializationTimeout: time.Second,
+		CalculationTimeout:    time.Second,
+		IdleTimeout:           time.Minute,
+		HealthInterval:        time.Second,
+	}
+	c1 := mock.NewContainerMock(t)
+	c1.SetConfigMock.Expect(cfg).Return(nil)
+	c2 := mock.NewContainerMock(t)
+	c2.SetConfigMock.Expect(cfg).Return(nil)
+	r, cancelFn := newTestDeduplicatorWithContainers(t, 1, c1, c2)
+	defer cancelFn()
+
+	require.NoError(t, r.SetConfig(cfg))
+
+	cfg.IdleTimeout = 0
+	require.Error(t, r.SetConfig(cfg))
+}
+
 func newTestDeduplicator(t *testing.T, result int) (*RequestDeduplicator, context.CancelFunc) {
 	t.Helper()
 
@@ -106,19 +539,31 @@ func newTestDeduplicator(t *testing.T, result int) (*RequestDeduplicator, contex
 	c.CalculateMock.Set(func(ctx context.Context, input int) (i1 int, err error) {
 		return result, nil
 	})
+
+	return newTestDeduplicatorWithContainers(t, 1, c)
+}
+
+func newTestDeduplicatorWithContainers(
+	t *testing.T, concurrency int, cs ...container,
+) (*RequestDeduplicator, context.CancelFunc) {
+	t.Helper()
+
 	ctx, cancelFn := context.WithCancel(context.Background())
 	r := &RequestDeduplicator{
 		l:                   zap.L().Sugar(),
+		image:               "qual-2021",
 		seed:                1,
-		container:           c,
+		containers:          cs,
+		concurrency:         concurrency,
+		scheduling:          MostSubscribers{},
 		reqID:               atomic.NewInt64(0),
 		closeCtx:            ctx,
 		closeLoopFn:         cancelFn,
 		signalOfNewSub:      make(chan bool, 1),
 		mu:                  sync.Mutex{},
 		inputToSubsriptions: make(map[int]map[int]*subscription),
-		curInput:            0,
-		cancelCurCalcFn:     nil,
+		inputToCancelCalcFn: make(map[int]context.CancelFunc),
+		inputToLastEvent:    make(map[int]progress.Event),
 	}
 	go r.Start()
 
@@ -0,0 +1,108 @@
+package deduplicator
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultAgingPeriod is a waiting time that weighs as one more subscriber in Aging.
+const DefaultAgingPeriod = 10 * time.Second
+
+// SchedulingPolicy chooses the next input for a calculation among waiting ones.
+type SchedulingPolicy interface {
+	// Choose returns an index in the queue. The queue is not empty and is ordered
+	// by the oldest waiter. Queue positions reported to subscribers sort inputs by
+	// choices between pairs, so such choices should be a consistent order that does
+	// not depend on other inputs.
+	Choose(now time.Time, queue []QueuedInput) int
+}
+
+// QueuedInput describes an input that waits for a calculation.
+type QueuedInput struct {
+	Input       int
+	Subscribers int
+	// OldestWait is when the oldest subscriber started waiting.
+	OldestWait time.Time
+	// EarliestDeadline is the earliest deadline of subscribers' requests, zero if there are no deadlines.
+	EarliestDeadline time.Time
+}
+
+// NewSchedulingPolicy returns a policy by its name: most-subscribers, fifo, aging or deadline.
+// agingPeriod is used by aging only.
+func NewSchedulingPolicy(name string, agingPeriod time.Duration) (SchedulingPolicy, error) {
+	switch name {
+	case "most-subscribers":
+		return MostSubscribers{}, nil
+	case "fifo":
+		return FIFO{}, nil
+	case "aging":
+		if agingPeriod <= 0 {
+			return nil, fmt.Errorf("aging period should be positive, got %s", agingPeriod)
+		}
+		return Aging{Period: agingPeriod}, nil
+	case "deadline":
+		return DeadlineFirst{}, nil
+	default:
+		return nil, fmt.Errorf("unknown scheduling policy %q", name)
+	}
+}
+
+// MostSubscribers chooses the input with the most subscribers, the oldest one among equal.
+// Unpopular inputs may starve under a steady load.
+type MostSubscribers struct{}
+
+func (MostSubscribers) Choose(_ time.Time, queue []QueuedInput) int {
+	best := 0
+	for i, q := range queue {
+		if q.Subscribers > queue[best].Subscribers {
+			best = i
+		}
+	}
+
+	return best
+}
+
+// FIFO chooses the input with the oldest waiter.
+type FIFO struct{}
+
+func (FIFO) Choose(time.Time, []QueuedInput) int {
+	return 0
+}
+
+// Aging chooses the input with the highest priority, which is the count of subscribers
+// plus one for every Period of waiting of the oldest subscriber.
+type Aging struct {
+	Period time.Duration
+}
+
+func (a Aging) Choose(now time.Time, queue []QueuedInput) int {
+	best, bestPriority := 0, 0.0
+	for i, q := range queue {
+		priority := float64(q.Subscribers) + float64(now.Sub(q.OldestWait))/float64(a.Period)
+		if i == 0 || priority > bestPriority {
+			best, bestPriority = i, priority
+		}
+	}
+
+	return best
+}
+
+// DeadlineFirst chooses the input with the earliest deadline, inputs without deadlines
+// are chosen after them by the oldest waiter.
+type DeadlineFirst struct{}
+
+func (DeadlineFirst) Choose(_ time.Time, queue []QueuedInput) int {
+	best := 0
+	for i, q := range queue {
+		if q.EarliestDeadline.IsZero() {
+			continue
+		}
+
+		bestDeadline := queue[best].EarliestDeadline
+		if bestDeadline.IsZero() || q.EarliestDeadline.Before(bestDeadline) {
+			best = i
+		}
+	}
+
+	return best
+}
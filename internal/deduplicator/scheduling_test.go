@@ -0,0 +1,126 @@
+package deduplicator
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Snyssfx/container_scheduler/internal/deduplicator/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// simulateStarvation feeds the policy with one unpopular input and a stream of new popular ones,
+// one calculation per second, and returns the step when the unpopular input was chosen or -1.
+func simulateStarvation(p SchedulingPolicy, steps int) int {
+	start := time.Now()
+	queue := []QueuedInput{{Input: 0, Subscribers: 1, OldestWait: start, EarliestDeadline: start.Add(time.Minute)}}
+
+	for step := 1; step <= steps; step++ {
+		now := start.Add(time.Duration(step) * time.Second)
+		queue = append(queue, QueuedInput{Input: step, Subscribers: 5, OldestWait: now})
+
+		i := p.Choose(now, queue)
+		if queue[i].Input == 0 {
+			return step
+		}
+		queue = append(queue[:i], queue[i+1:]...)
+	}
+
+	return -1
+}
+
+func TestSchedulingPolicy_Starvation(t *testing.T) {
+	assert.Equal(t, -1, simulateStarvation(MostSubscribers{}, 1000))
+	assert.Equal(t, 1, simulateStarvation(FIFO{}, 1000))
+	assert.Equal(t, 1, simulateStarvation(DeadlineFirst{}, 1000))
+
+	step := simulateStarvation(Aging{Period: DefaultAgingPeriod}, 1000)
+	assert.Greater(t, step, 1)
+	assert.LessOrEqual(t, step, 50)
+}
+
+func TestDeadlineFirst_Choose(t *testing.T) {
+	now := time.Now()
+	queue := []QueuedInput{
+		{Input: 1, OldestWait: now.Add(-3 * time.Second)},
+		{Input: 2, OldestWait: now.Add(-2 * time.Second), EarliestDeadline: now.Add(time.Minute)},
+		{Input: 3, OldestWait: now.Add(-1 * time.Second), EarliestDeadline: now.Add(time.Second)},
+	}
+
+	assert.Equal(t, 2, DeadlineFirst{}.Choose(now, queue))
+	assert.Equal(t, 0, DeadlineFirst{}.Choose(now, queue[:1]))
+}
+
+func TestNewSchedulingPolicy(t *testing.T) {
+	for _, name := range []string{"most-subscribers", "fifo", "aging", "deadline"} {
+		_, err := NewSchedulingPolicy(name, DefaultAgingPeriod)
+		require.NoError(t, err)
+	}
+
+	_, err := NewSchedulingPolicy("random", DefaultAgingPeriod)
+	require.Error(t, err)
+
+	_, err = NewSchedulingPolicy("aging", 0)
+	require.Error(t, err)
+}
+
+func TestRequestDeduplicator_Calculate_FIFO(t *testing.T) {
+	var order []int
+	mu := sync.Mutex{}
+	release := make(chan struct{})
+
+	c := mock.NewContainerMock(t)
+	c.CalculateMock.Set(func(ctx context.Context, input int) (i1 int, err error) {
+		if input == 0 {
+			<-release
+		}
+		mu.Lock()
+		order = append(order, input)
+		mu.Unlock()
+		return input, nil
+	})
+	r, closeFn := newTestDeduplicatorWithContainers(t, 1, c)
+	defer closeFn()
+	r.scheduling = FIFO{}
+
+	wg := sync.WaitGroup{}
+	calculate := func(input int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.Calculate(context.Background(), input)
+			require.NoError(t, err)
+		}()
+	}
+
+	// the worker is busy with 0, meanwhile 1 waits alone and 2 gets many subscribers.
+	calculate(0)
+	waitSubscribers(t, r, 1)
+	calculate(1)
+	waitSubscribers(t, r, 2)
+	for i := 0; i < 5; i++ {
+		calculate(2)
+	}
+	waitSubscribers(t, r, 7)
+
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, []int{0, 1, 2}, order)
+}
+
+func waitSubscribers(t *testing.T, r *RequestDeduplicator, n int) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		subs := 0
+		for _, inputSubs := range r.inputToSubsriptions {
+			subs += len(inputSubs)
+		}
+		return subs == n
+	}, time.Second, time.Millisecond)
+}
@@ -0,0 +1,212 @@
+//go:generate minimock -i warmer -o ./mock/ -s ".go" -g
+
+package forecast
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Snyssfx/container_scheduler/internal/containersmap"
+	"github.com/Snyssfx/container_scheduler/internal/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	// burstGap joins requests that are closer than it into one arrival.
+	burstGap = 10 * time.Second
+	// minArrivals is a count of arrivals after which a seed is predicted.
+	minArrivals = 3
+	// forgetAfter is a time after the last arrival when a seed is not tracked anymore.
+	forgetAfter = 24 * time.Hour
+	// maxSeeds is a maximum count of tracked seeds, the least recently observed one is forgotten.
+	maxSeeds = 10000
+	// tickInterval is a period of predictions.
+	tickInterval = 5 * time.Second
+	// warmWait is a maximum time for a predicted seed to wait for a free container.
+	warmWait = time.Second
+)
+
+// Config holds settings of a Forecaster.
+type Config struct {
+	// Alpha is a weight of the latest interval in the moving averages, in (0, 1].
+	Alpha float64
+	// Lead is how long before an expected arrival the seed is warmed.
+	Lead time.Duration
+}
+
+// Validate checks that the config is consistent.
+func (cfg Config) Validate() error {
+	if cfg.Alpha <= 0 || cfg.Alpha > 1 {
+		return fmt.Errorf("alpha %v should be in (0, 1]", cfg.Alpha)
+	}
+	if cfg.Lead <= 0 {
+		return fmt.Errorf("lead %s should be positive", cfg.Lead)
+	}
+
+	return nil
+}
+
+type warmer interface {
+	Warm(ctx context.Context, image string, seed int) error
+}
+
+// stats is an arrival history of a seed.
+type stats struct {
+	// last is the last request of the last arrival.
+	last     time.Time
+	arrivals int
+	// interval and deviation are exponential moving averages of intervals between arrivals
+	// and of their absolute deviations.
+	interval  time.Duration
+	deviation time.Duration
+	// warmedFor is the expected arrival that the seed was warmed for.
+	warmedFor time.Time
+	// elem is the seed in the order of observations.
+	elem *list.Element
+}
+
+func (s *stats) observe(at time.Time, alpha float64) {
+	gap := at.Sub(s.last)
+	if s.arrivals > 0 && gap < burstGap {
+		s.last = at
+		return
+	}
+
+	switch s.arrivals {
+	case 0:
+	case 1:
+		s.interval, s.deviation = gap, gap/2
+	default:
+		diff := time.Duration(math.Abs(float64(gap - s.interval)))
+		s.deviation = time.Duration((1-alpha)*float64(s.deviation) + alpha*float64(diff))
+		s.interval = time.Duration((1-alpha)*float64(s.interval) + alpha*float64(gap))
+	}
+
+	s.last = at
+	s.arrivals++
+}
+
+// next returns the expected time of the next arrival.
+func (s *stats) next() time.Time {
+	return s.last.Add(s.interval)
+}
+
+// Forecaster records arrivals of requests to seeds and warms seeds shortly before
+// expected arrivals.
+type Forecaster struct {
+	l      *zap.SugaredLogger
+	warmer warmer
+	alpha  float64
+	lead   time.Duration
+	now    func() time.Time
+	// maxSeeds is a maximum count of tracked seeds.
+	maxSeeds int
+
+	mu    sync.Mutex
+	stats map[containersmap.Key]*stats
+	// observed holds keys of stats, the most recently observed is at the front.
+	observed *list.List
+}
+
+// New creates Forecaster.
+func New(l *zap.SugaredLogger, w warmer, cfg Config) (*Forecaster, error) {
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Forecaster{
+		l:        l,
+		warmer:   w,
+		alpha:    cfg.Alpha,
+		lead:     cfg.Lead,
+		now:      time.Now,
+		maxSeeds: maxSeeds,
+
+		mu:       sync.Mutex{},
+		stats:    make(map[containersmap.Key]*stats),
+		observed: list.New(),
+	}, nil
+}
+
+// Run makes predictions until the context is done.
+func (f *Forecaster) Run(ctx context.Context) {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			f.tick(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// tick warms seeds with arrivals expected soon.
+func (f *Forecaster) tick(ctx context.Context) {
+	now := f.now()
+	var toWarm []containersmap.Key
+
+	f.mu.Lock()
+	for key, s := range f.stats {
+		if now.Sub(s.last) > forgetAfter {
+			f.forget(key, s)
+			continue
+		}
+		if s.arrivals < minArrivals {
+			continue
+		}
+
+		next := s.next()
+		if !s.warmedFor.Equal(next) && !now.Before(next.Add(-f.lead)) && now.Before(next.Add(s.deviation)) {
+			s.warmedFor = next
+			toWarm = append(toWarm, key)
+		}
+	}
+	f.mu.Unlock()
+
+	for _, key := range toWarm {
+		warmCtx, cancelFn := context.WithTimeout(ctx, warmWait)
+		err := f.warmer.Warm(warmCtx, key.Image, key.Seed)
+		cancelFn()
+		if err != nil {
+			f.l.Warnf("cannot warm seed %d of %s before an expected request: %s", key.Seed, key.Image, err.Error())
+			continue
+		}
+
+		metrics.PredictiveWarms.Inc()
+		f.l.Infof("seed %d of %s is warmed before an expected request", key.Seed, key.Image)
+	}
+}
+
+// Observe records a request of the key that needs containers, requests answered from
+// the cache are not observed as they do not need warm containers.
+func (f *Forecaster) Observe(key containersmap.Key) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, ok := f.stats[key]
+	if !ok {
+		s = &stats{elem: f.observed.PushFront(key)}
+		f.stats[key] = s
+	}
+	f.observed.MoveToFront(s.elem)
+	s.observe(f.now(), f.alpha)
+
+	for len(f.stats) > f.maxSeeds {
+		oldest := f.observed.Back().Value.(containersmap.Key)
+		f.forget(oldest, f.stats[oldest])
+	}
+}
+
+// forget stops tracking the key. It is called under the mutex.
+func (f *Forecaster) forget(key containersmap.Key, s *stats) {
+	f.observed.Remove(s.elem)
+	delete(f.stats, key)
+}
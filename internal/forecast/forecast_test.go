@@ -0,0 +1,88 @@
+package forecast
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Snyssfx/container_scheduler/internal/containersmap"
+	"github.com/Snyssfx/container_scheduler/internal/forecast/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestStats_observe(t *testing.T) {
+	start := time.Now()
+	s := &stats{}
+
+	s.observe(start, 0.5)
+	s.observe(start.Add(time.Second), 0.5) // the same burst
+	s.observe(start.Add(4*time.Minute+time.Second), 0.5)
+	assert.Equal(t, 2, s.arrivals)
+	assert.Equal(t, 4*time.Minute, s.interval)
+	assert.Equal(t, 2*time.Minute, s.deviation)
+
+	s.observe(start.Add(10*time.Minute+time.Second), 0.5)
+	assert.Equal(t, 3, s.arrivals)
+	assert.Equal(t, 5*time.Minute, s.interval)
+	assert.Equal(t, 2*time.Minute, s.deviation)
+	assert.Equal(t, start.Add(15*time.Minute+time.Second), s.next())
+}
+
+func TestForecaster_tick(t *testing.T) {
+	key := containersmap.Key{Image: "qual-2021", Seed: 1}
+	w := mock.NewWarmerMock(t)
+	w.WarmMock.Set(func(ctx context.Context, image string, seed int) (err error) {
+		assert.Equal(t, key, containersmap.Key{Image: image, Seed: seed})
+		return nil
+	})
+	f, err := New(zap.NewNop().Sugar(), w, Config{Alpha: 0.5, Lead: time.Minute})
+	require.NoError(t, err)
+	now := time.Now()
+	f.now = func() time.Time { return now }
+
+	start := now
+	for _, at := range []time.Duration{0, 4 * time.Minute, 10 * time.Minute} {
+		now = start.Add(at)
+		f.Observe(key)
+	}
+
+	// the next arrival is expected in 5 minutes
+	f.tick(context.Background())
+	assert.Equal(t, uint64(0), w.WarmAfterCounter())
+
+	now = now.Add(4*time.Minute + 30*time.Second)
+	f.tick(context.Background())
+	f.tick(context.Background())
+	assert.Equal(t, uint64(1), w.WarmAfterCounter())
+
+	now = now.Add(forgetAfter)
+	f.tick(context.Background())
+	assert.Empty(t, f.stats)
+	assert.Zero(t, f.observed.Len())
+}
+
+func TestForecaster_Observe_MaxSeeds(t *testing.T) {
+	f, err := New(zap.NewNop().Sugar(), mock.NewWarmerMock(t), Config{Alpha: 0.5, Lead: time.Minute})
+	require.NoError(t, err)
+	f.maxSeeds = 2
+	key := func(seed int) containersmap.Key { return containersmap.Key{Image: "qual-2021", Seed: seed} }
+
+	// the least recently observed seed is forgotten
+	f.Observe(key(1))
+	f.Observe(key(2))
+	f.Observe(key(1))
+	f.Observe(key(3))
+
+	assert.Len(t, f.stats, 2)
+	assert.Contains(t, f.stats, key(1))
+	assert.Contains(t, f.stats, key(3))
+	assert.Equal(t, 2, f.observed.Len())
+}
+
+func TestConfig_Validate(t *testing.T) {
+	assert.NoError(t, Config{Alpha: 1, Lead: time.Minute}.Validate())
+	assert.Error(t, Config{Alpha: 0, Lead: time.Minute}.Validate())
+	assert.Error(t, Config{Alpha: 0.5, Lead: 0}.Validate())
+}
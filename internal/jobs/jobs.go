@@ -0,0 +1,251 @@
+//go:generate minimock -i calculator -o ./mock/ -s ".go" -g
+
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrNotFound is returned for unknown and expired jobs.
+	ErrNotFound = errors.New("job not found")
+	// ErrTooManyJobs is returned when the count of stored jobs reaches the limit.
+	ErrTooManyJobs = errors.New("too many jobs")
+	// ErrClosed is returned for jobs submitted after Close.
+	ErrClosed = errors.New("job manager is closed")
+)
+
+// Status is a stage of a job.
+type Status string
+
+const (
+	StatusRunning  Status = "running"
+	StatusDone     Status = "done"
+	StatusFailed   Status = "failed"
+	StatusCanceled Status = "canceled"
+)
+
+// Job is a calculation that runs in background.
+type Job struct {
+	ID         string     `json:"id"`
+	Image      string     `json:"image"`
+	Seed       int        `json:"seed"`
+	Input      int        `json:"input"`
+	Status     Status     `json:"status"`
+	Result     *int       `json:"result,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Config holds settings of a Manager.
+type Config struct {
+	// TTL is a time a finished job is kept after it finishes.
+	TTL time.Duration
+	// MaxJobs is a maximum count of stored jobs, running and finished ones.
+	MaxJobs int
+}
+
+// Validate checks that the config is consistent.
+func (cfg Config) Validate() error {
+	if cfg.TTL <= 0 || cfg.MaxJobs < 1 {
+		return fmt.Errorf("ttl %s and max jobs %d should be positive", cfg.TTL, cfg.MaxJobs)
+	}
+
+	return nil
+}
+
+type calculator interface {
+	Calculate(ctx context.Context, image string, seed, input int) (int, error)
+}
+
+type job struct {
+	Job
+	cancelFn context.CancelFunc
+}
+
+// Manager runs calculations in background and keeps their results for a while.
+// Jobs of the same input share one calculation in the deduplicator of the seed.
+type Manager struct {
+	l          *zap.SugaredLogger
+	calculator calculator
+	ttl        time.Duration
+	maxJobs    int
+	now        func() time.Time
+	wg         sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+	jobs   map[string]*job
+}
+
+// New creates Manager.
+func New(l *zap.SugaredLogger, c calculator, cfg Config) (*Manager, error) {
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Manager{
+		l:          l,
+		calculator: c,
+		ttl:        cfg.TTL,
+		maxJobs:    cfg.MaxJobs,
+		now:        time.Now,
+		wg:         sync.WaitGroup{},
+
+		mu:   sync.Mutex{},
+		jobs: make(map[string]*job),
+	}, nil
+}
+
+// Submit starts a calculation and returns its job.
+func (m *Manager) Submit(image string, seed, input int) (Job, error) {
+	id, err := newID()
+	if err != nil {
+		return Job{}, fmt.Errorf("cannot generate job id: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return Job{}, ErrClosed
+	}
+
+	m.purgeExpired()
+	if len(m.jobs) >= m.maxJobs {
+		return Job{}, ErrTooManyJobs
+	}
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	j := &job{
+		Job: Job{
+			ID:        id,
+			Image:     image,
+			Seed:      seed,
+			Input:     input,
+			Status:    StatusRunning,
+			CreatedAt: m.now(),
+		},
+		cancelFn: cancelFn,
+	}
+	m.jobs[id] = j
+
+	m.wg.Add(1)
+	go m.run(ctx, j)
+
+	m.l.Debugf("job %s submitted for input %d of seed %d of %s", id, input, seed, image)
+	return j.Job, nil
+}
+
+// Get returns the current state of the job.
+func (m *Manager) Get(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.jobs[id]
+	if !ok || m.expired(j) {
+		return Job{}, ErrNotFound
+	}
+
+	return j.Job, nil
+}
+
+// Cancel stops the running job, a finished job is not changed.
+func (m *Manager) Cancel(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.jobs[id]
+	if !ok || m.expired(j) {
+		return Job{}, ErrNotFound
+	}
+
+	if j.Status == StatusRunning {
+		m.finish(j, StatusCanceled)
+		j.cancelFn()
+	}
+
+	return j.Job, nil
+}
+
+// Close cancels all running jobs and waits for them.
+func (m *Manager) Close() {
+	m.mu.Lock()
+	m.closed = true
+	for _, j := range m.jobs {
+		if j.Status == StatusRunning {
+			m.finish(j, StatusCanceled)
+			j.cancelFn()
+		}
+	}
+	m.mu.Unlock()
+
+	m.wg.Wait()
+	m.l.Infof("jobs closed")
+}
+
+// run calculates the job and stores the result unless the job is canceled.
+func (m *Manager) run(ctx context.Context, j *job) {
+	defer m.wg.Done()
+	defer j.cancelFn()
+
+	result, err := m.calculator.Calculate(ctx, j.Image, j.Seed, j.Input)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if j.Status != StatusRunning {
+		return
+	}
+
+	if err != nil {
+		m.l.Errorf("job %s failed: %s", j.ID, err.Error())
+		j.Error = err.Error()
+		m.finish(j, StatusFailed)
+		return
+	}
+
+	j.Result = &result
+	m.finish(j, StatusDone)
+}
+
+// finish sets the final status. It is called under the mutex.
+func (m *Manager) finish(j *job, status Status) {
+	finishedAt := m.now()
+	j.Status = status
+	j.FinishedAt = &finishedAt
+}
+
+// expired reports if the finished job is older than the ttl. It is called under the mutex.
+func (m *Manager) expired(j *job) bool {
+	return j.FinishedAt != nil && m.now().Sub(*j.FinishedAt) > m.ttl
+}
+
+// purgeExpired removes expired jobs. It is called under the mutex.
+func (m *Manager) purgeExpired() {
+	for id, j := range m.jobs {
+		if m.expired(j) {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
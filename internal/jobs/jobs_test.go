@@ -0,0 +1,124 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Snyssfx/container_scheduler/internal/jobs/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestManager_Submit(t *testing.T) {
+	c := mock.NewCalculatorMock(t)
+	c.CalculateMock.Set(func(ctx context.Context, image string, seed int, input int) (i1 int, err error) {
+		assert.Equal(t, "qual-2021", image)
+		if input < 0 {
+			return 0, errors.New("negative input")
+		}
+		return seed + input, nil
+	})
+	m, err := New(zap.NewNop().Sugar(), c, Config{TTL: time.Minute, MaxJobs: 10})
+	require.NoError(t, err)
+
+	ok, err := m.Submit("qual-2021", 1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, StatusRunning, ok.Status)
+	failed, err := m.Submit("qual-2021", 1, -1)
+	require.NoError(t, err)
+	assert.NotEqual(t, ok.ID, failed.ID)
+
+	m.wg.Wait()
+
+	got, err := m.Get(ok.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusDone, got.Status)
+	assert.Equal(t, 3, *got.Result)
+	assert.NotNil(t, got.FinishedAt)
+
+	got, err = m.Get(failed.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusFailed, got.Status)
+	assert.Nil(t, got.Result)
+	assert.Equal(t, "negative input", got.Error)
+
+	_, err = m.Get("unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestManager_Cancel(t *testing.T) {
+	c := mock.NewCalculatorMock(t)
+	c.CalculateMock.Set(func(ctx context.Context, image string, seed int, input int) (i1 int, err error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	m, err := New(zap.NewNop().Sugar(), c, Config{TTL: time.Minute, MaxJobs: 10})
+	require.NoError(t, err)
+
+	j, err := m.Submit("qual-2021", 1, 2)
+	require.NoError(t, err)
+
+	got, err := m.Cancel(j.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCanceled, got.Status)
+
+	m.wg.Wait()
+
+	got, err = m.Get(j.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCanceled, got.Status)
+	assert.Empty(t, got.Error)
+
+	_, err = m.Cancel("unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestManager_Expiration(t *testing.T) {
+	c := mock.NewCalculatorMock(t)
+	c.CalculateMock.Return(1, nil)
+	m, err := New(zap.NewNop().Sugar(), c, Config{TTL: time.Minute, MaxJobs: 1})
+	require.NoError(t, err)
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	j, err := m.Submit("qual-2021", 1, 2)
+	require.NoError(t, err)
+	m.wg.Wait()
+
+	_, err = m.Submit("qual-2021", 1, 3)
+	assert.ErrorIs(t, err, ErrTooManyJobs)
+
+	now = now.Add(2 * time.Minute)
+	_, err = m.Get(j.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = m.Submit("qual-2021", 1, 3)
+	require.NoError(t, err)
+	m.wg.Wait()
+	assert.Len(t, m.jobs, 1)
+}
+
+func TestManager_Close(t *testing.T) {
+	c := mock.NewCalculatorMock(t)
+	c.CalculateMock.Set(func(ctx context.Context, image string, seed int, input int) (i1 int, err error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	m, err := New(zap.NewNop().Sugar(), c, Config{TTL: time.Minute, MaxJobs: 10})
+	require.NoError(t, err)
+
+	j, err := m.Submit("qual-2021", 1, 2)
+	require.NoError(t, err)
+
+	m.Close()
+
+	got, err := m.Get(j.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCanceled, got.Status)
+
+	_, err = m.Submit("qual-2021", 1, 2)
+	assert.ErrorIs(t, err, ErrClosed)
+}
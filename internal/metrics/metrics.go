@@ -0,0 +1,119 @@
+// Package metrics holds Prometheus collectors of the scheduler.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "container_scheduler"
+
+var (
+	// Requests counts user requests by HTTP status.
+	Requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Count of user requests by HTTP status.",
+	}, []string{"status"})
+
+	// RequestDuration observes user request latencies by HTTP status.
+	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "Latency of user requests by HTTP status.",
+		Buckets:   []float64{0.005, 0.05, 0.5, 1, 5, 10, 30, 60, 130, 260},
+	}, []string{"status"})
+
+	// CacheHits counts results returned from the cache.
+	CacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_hits_total",
+		Help:      "Count of results returned from the cache.",
+	})
+
+	// CacheMisses counts results that were not found in the cache.
+	CacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_misses_total",
+		Help:      "Count of results that were not found in the cache.",
+	})
+
+	// QueueDepth is a count of distinct inputs waiting for a calculation in all deduplicators.
+	QueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "deduplicator_queue_depth",
+		Help:      "Count of distinct inputs waiting for a calculation.",
+	})
+
+	// DedupFanOut observes how many subscribers receive one calculated result.
+	DedupFanOut = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "deduplicator_fan_out",
+		Help:      "Count of subscribers that received one calculated result.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	})
+
+	// QualStarts counts container starts by result: ok or error.
+	QualStarts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "qual_starts_total",
+		Help:      "Count of container starts by result.",
+	}, []string{"result"})
+
+	// QualStops counts stopped containers.
+	QualStops = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "qual_stops_total",
+		Help:      "Count of stopped containers.",
+	})
+
+	// QualRetries counts repeated calculations after failures of containers.
+	QualRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "qual_retries_total",
+		Help:      "Count of repeated calculations after failures of containers.",
+	})
+
+	// QualRestarts counts unhealthy containers stopped to be started again.
+	QualRestarts = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "qual_restarts_total",
+		Help:      "Count of unhealthy containers stopped to be started again.",
+	})
+
+	// CircuitOpens counts breakers of seeds opened after failures of their containers.
+	CircuitOpens = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "circuit_opens_total",
+		Help:      "Count of breakers of seeds opened after failures of their containers.",
+	})
+
+	// SeedsCollected counts seeds removed from the containers map after being idle.
+	SeedsCollected = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "seeds_collected_total",
+		Help:      "Count of idle seeds removed from the containers map.",
+	})
+
+	// PredictiveWarms counts seeds warmed before an expected request.
+	PredictiveWarms = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "predictive_warms_total",
+		Help:      "Count of seeds warmed before an expected request.",
+	})
+
+	// QualIdleTimeout is the current idle timeout of containers of a seed.
+	QualIdleTimeout = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "qual_idle_timeout_seconds",
+		Help:      "Current idle timeout of containers of a seed.",
+	}, []string{"image", "seed"})
+
+	// QualInitDuration observes how long containers initialize.
+	QualInitDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "qual_initialization_duration_seconds",
+		Help:      "Duration of a container start and initialization.",
+		Buckets:   []float64{1, 2, 5, 10, 20, 30, 60, 90, 130},
+	})
+)
@@ -0,0 +1,57 @@
+package progress
+
+import "context"
+
+// Stage is a step of a calculation that a user waits for.
+type Stage string
+
+const (
+	// StageQueued means the input waits for a free worker.
+	StageQueued Stage = "queued"
+	// StageStarting means the container of the seed is being started.
+	StageStarting Stage = "starting"
+	// StageHealthCheck means the container is being checked for readiness.
+	StageHealthCheck Stage = "health_check"
+	// StageCalculating means the input is sent to the container.
+	StageCalculating Stage = "calculating"
+	// StageDone means the result is ready.
+	StageDone Stage = "done"
+	// StageError means the calculation failed.
+	StageError Stage = "error"
+)
+
+// Event is a change of the stage of a calculation.
+type Event struct {
+	Stage Stage `json:"stage"`
+	// Position is a place of the input in the queue, 1 is the next one. It is set for StageQueued.
+	Position int `json:"position,omitempty"`
+	// Attempt is a number of the health check, it is set for StageHealthCheck.
+	Attempt int    `json:"attempt,omitempty"`
+	Result  *int   `json:"result,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// Status is an HTTP status of the error, it is set for StageError.
+	Status int `json:"status,omitempty"`
+}
+
+// Reporter receives events of a calculation. It is called under locks, so it must not block.
+type Reporter func(Event)
+
+type reporterKey struct{}
+
+// WithReporter returns a context that passes events of calculations to the reporter.
+func WithReporter(ctx context.Context, reporter Reporter) context.Context {
+	return context.WithValue(ctx, reporterKey{}, reporter)
+}
+
+// FromContext returns the reporter of the context, nil if there is none.
+func FromContext(ctx context.Context) Reporter {
+	reporter, _ := ctx.Value(reporterKey{}).(Reporter)
+	return reporter
+}
+
+// Report passes the event to the reporter of the context if there is one.
+func Report(ctx context.Context, e Event) {
+	if reporter := FromContext(ctx); reporter != nil {
+		reporter(e)
+	}
+}
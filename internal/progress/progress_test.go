@@ -0,0 +1,20 @@
+package progress
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReport(t *testing.T) {
+	Report(context.Background(), Event{Stage: StageQueued})
+	assert.Nil(t, FromContext(context.Background()))
+
+	var got []Event
+	ctx := WithReporter(context.Background(), func(e Event) { got = append(got, e) })
+	Report(ctx, Event{Stage: StageQueued, Position: 2})
+	Report(ctx, Event{Stage: StageStarting})
+
+	assert.Equal(t, []Event{{Stage: StageQueued, Position: 2}, {Stage: StageStarting}}, got)
+}
@@ -0,0 +1,130 @@
+//go:generate minimock -i inventory -o ./mock/ -s ".go" -g
+
+package reconciler
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/Snyssfx/container_scheduler/internal/containers"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+)
+
+// Policy is what to do with containers left by a previous run.
+type Policy string
+
+const (
+	// PolicyAdopt takes healthy containers under control and removes the others.
+	PolicyAdopt Policy = "adopt"
+	// PolicyRemove removes all containers.
+	PolicyRemove Policy = "remove"
+)
+
+// Validate checks that the policy is known.
+func (p Policy) Validate() error {
+	switch p {
+	case PolicyAdopt, PolicyRemove:
+		return nil
+	default:
+		return fmt.Errorf("unknown orphans policy %q", p)
+	}
+}
+
+type inventory interface {
+	List() ([]containers.Orphan, error)
+	Remove(o containers.Orphan) error
+	Adopt(o containers.Orphan, spec containers.ImageSpec) (*containers.Qual, error)
+}
+
+type images interface {
+	Get(name string) (containers.ImageSpec, error)
+}
+
+// AdoptFunc takes adopted containers of one seed under control.
+// It owns the quals and closes them on error.
+type AdoptFunc func(image string, seed int, quals []*containers.Qual) error
+
+// Reconciler brings containers of a previous run in line with the current one on startup.
+type Reconciler struct {
+	l         *zap.SugaredLogger
+	policy    Policy
+	inventory inventory
+	images    images
+	adopt     AdoptFunc
+}
+
+// New creates Reconciler.
+func New(l *zap.SugaredLogger, policy Policy, inv inventory, images images, adopt AdoptFunc) *Reconciler {
+	return &Reconciler{
+		l:         l,
+		policy:    policy,
+		inventory: inv,
+		images:    images,
+		adopt:     adopt,
+	}
+}
+
+type key struct {
+	image string
+	seed  int
+}
+
+// Run adopts or removes every container of a previous run. Containers of unknown images
+// and unhealthy ones are removed. An error of one container does not stop the others.
+func (r *Reconciler) Run() error {
+	orphans, err := r.inventory.List()
+	if err != nil {
+		return fmt.Errorf("cannot list containers: %w", err)
+	}
+
+	var errs error
+	keyToQuals := make(map[key][]*containers.Qual)
+	for _, o := range orphans {
+		q, err := r.tryAdopt(o)
+		if err != nil {
+			r.l.Infof("removing container %s: %s", o.Name, err.Error())
+			errs = multierr.Append(errs, r.inventory.Remove(o))
+			continue
+		}
+
+		k := key{image: o.Image, seed: o.Seed}
+		keyToQuals[k] = append(keyToQuals[k], q)
+	}
+
+	keys := make([]key, 0, len(keyToQuals))
+	for k := range keyToQuals {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].image != keys[j].image {
+			return keys[i].image < keys[j].image
+		}
+		return keys[i].seed < keys[j].seed
+	})
+
+	for _, k := range keys {
+		err = r.adopt(k.image, k.seed, keyToQuals[k])
+		if err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("cannot adopt seed %d of %s: %w", k.seed, k.image, err))
+			continue
+		}
+		r.l.Infof("adopted %d containers of seed %d of %s", len(keyToQuals[k]), k.seed, k.image)
+	}
+
+	return errs
+}
+
+// tryAdopt returns a reason to remove the orphan if it cannot be adopted.
+func (r *Reconciler) tryAdopt(o containers.Orphan) (*containers.Qual, error) {
+	if r.policy == PolicyRemove {
+		return nil, fmt.Errorf("orphans policy is %s", r.policy)
+	}
+
+	spec, err := r.images.Get(o.Image)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.inventory.Adopt(o, spec)
+}
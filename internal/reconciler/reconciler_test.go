@@ -0,0 +1,74 @@
+package reconciler
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Snyssfx/container_scheduler/internal/containers"
+	"github.com/Snyssfx/container_scheduler/internal/reconciler/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestReconciler_Run(t *testing.T) {
+	orphans := []containers.Orphan{
+		{Name: "qual_1_seed_1", Image: "qual-2021", Seed: 1, Running: true},
+		{Name: "qual_2_seed_1", Image: "qual-2021", Seed: 1, Running: true},
+		{Name: "qual_3_seed_2", Image: "qual-2021", Seed: 2, Running: false},
+		{Name: "qual_4_seed_3", Image: "unknown", Seed: 3, Running: true},
+		{Name: "qual_5_seed_4", Image: "qual-2021", Seed: 4, Running: true},
+	}
+	inv := mock.NewInventoryMock(t)
+	inv.ListMock.Return(orphans, nil)
+	inv.AdoptMock.Set(func(o containers.Orphan, spec containers.ImageSpec) (qp1 *containers.Qual, err error) {
+		assert.Equal(t, containers.Qual2021, spec)
+		if !o.Running {
+			return nil, errors.New("not running")
+		}
+		return &containers.Qual{}, nil
+	})
+	var removed []string
+	inv.RemoveMock.Set(func(o containers.Orphan) (err error) {
+		removed = append(removed, o.Name)
+		return nil
+	})
+
+	adopted := map[int]int{}
+	r := New(zap.NewNop().Sugar(), PolicyAdopt, inv, containers.DefaultImages(),
+		func(image string, seed int, quals []*containers.Qual) error {
+			assert.Equal(t, "qual-2021", image)
+			adopted[seed] = len(quals)
+			if seed == 4 {
+				return errors.New("max containers reached")
+			}
+			return nil
+		})
+
+	err := r.Run()
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot adopt seed 4 of qual-2021: max containers reached")
+	assert.Equal(t, map[int]int{1: 2, 4: 1}, adopted)
+	assert.Equal(t, []string{"qual_3_seed_2", "qual_4_seed_3"}, removed)
+}
+
+func TestReconciler_Run_Remove(t *testing.T) {
+	inv := mock.NewInventoryMock(t)
+	inv.ListMock.Return([]containers.Orphan{{Name: "qual_1_seed_1", Image: "qual-2021", Seed: 1, Running: true}}, nil)
+	inv.RemoveMock.Return(errors.New("docker is down"))
+
+	r := New(zap.NewNop().Sugar(), PolicyRemove, inv, containers.DefaultImages(),
+		func(image string, seed int, quals []*containers.Qual) error {
+			t.Fatal("unexpected adoption")
+			return nil
+		})
+
+	assert.EqualError(t, r.Run(), "docker is down")
+}
+
+func TestPolicy_Validate(t *testing.T) {
+	assert.NoError(t, PolicyAdopt.Validate())
+	assert.NoError(t, PolicyRemove.Validate())
+	assert.Error(t, Policy("keep").Validate())
+}
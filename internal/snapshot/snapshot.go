@@ -0,0 +1,58 @@
+// Package snapshot holds states of seeds and their containers for introspection.
+package snapshot
+
+import "time"
+
+// States of a container in Container.State.
+const (
+	StateInit    = "init"
+	StateReady   = "ready"
+	StateStopped = "stopped"
+)
+
+// States of a breaker of a seed in Seed.Circuit.
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// Container is a state of a docker container of a seed.
+type Container struct {
+	Name            string    `json:"name"`
+	Port            int       `json:"port"`
+	State           string    `json:"state"`
+	Pinned          bool      `json:"pinned"`
+	InFlight        int       `json:"in_flight"`
+	LastCalculation time.Time `json:"last_calculation"`
+	IdleTimeout     string    `json:"idle_timeout"`
+}
+
+// Input is an input with a count of its subscribers.
+type Input struct {
+	Input       int `json:"input"`
+	Subscribers int `json:"subscribers"`
+}
+
+// Deduplicator is a state of a deduplicator: its containers, inputs being calculated
+// and waiting inputs in the order of the scheduling policy.
+type Deduplicator struct {
+	Containers  []Container `json:"containers"`
+	Calculating []Input     `json:"calculating"`
+	Queued      []Input     `json:"queued"`
+	// CachedResults is a count of cached results of the seed in memory.
+	CachedResults int `json:"cached_results"`
+}
+
+// Seed is a state of an image and a seed in the containers map.
+type Seed struct {
+	Image  string `json:"image"`
+	Seed   int    `json:"seed"`
+	Pinned bool   `json:"pinned"`
+	// Requests is a count of requests to the seed in flight.
+	Requests int       `json:"requests"`
+	LastUsed time.Time `json:"last_used"`
+	// Circuit is a state of the breaker of the seed.
+	Circuit string `json:"circuit"`
+	Deduplicator
+}